@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+)
+
+// separator of archive path and member path, e.g. "a.zip!dir/b.txt".
+const archiveSep = "!"
+
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveKind returns kind of archive by name, empty is not archive.
+func archiveKind(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	}
+	return ""
+}
+
+// SetReadArchive reads regular files in ".zip", ".tar" and ".tar.gz" files,
+// path of the results are "archive!member".
+func (w *Walker) SetReadArchive(read bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.readArchive = read
+	return nil
+}
+
+// readArchiveFile reads members of the archive, and calls send for each
+// results.
+func (w *Walker) readArchiveFile(fr *FileReader, path string, send func(*File, error)) {
+	f, err := w.open(path)
+	if err != nil {
+		send(nil, err)
+		return
+	}
+	defer f.Close()
+	switch archiveKind(path) {
+	case archiveZip:
+		err = w.readZip(fr, path, f, send)
+	case archiveTar:
+		err = w.readTar(fr, path, f, send)
+	case archiveTarGz:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(f); err == nil {
+			err = w.readTar(fr, path, zr, send)
+			zr.Close()
+		}
+	}
+	// corrupted
+	if err != nil {
+		send(nil, &ExpectedError{path: path, err: err})
+	}
+}
+
+func (w *Walker) readZip(fr *FileReader, path string, f fs.File, send func(*File, error)) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		ra = bytes.NewReader(b)
+	}
+	zr, err := zip.NewReader(ra, fi.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() || !w.isAllowedExt(zf.Name) {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		send(w.readMember(fr, path+archiveSep+zf.Name, r))
+		r.Close()
+	}
+	return nil
+}
+
+func (w *Walker) readTar(fr *FileReader, path string, r io.Reader, send func(*File, error)) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() || !w.isAllowedExt(hdr.Name) {
+			continue
+		}
+		send(w.readMember(fr, path+archiveSep+hdr.Name, tr))
+	}
+}
+
+// readMember is read for a member of archives, errors of corrupted member
+// are expected.
+func (w *Walker) readMember(fr *FileReader, path string, r io.Reader) (*File, error) {
+	f, err := w.read(fr, path, r)
+	if err != nil {
+		if _, ok := err.(*ExpectedError); ok {
+			return nil, err
+		}
+		return nil, &ExpectedError{path: path, err: err}
+	}
+	return f, nil
+}
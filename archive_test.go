@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReadArchive(t *testing.T) {
+	tmp := tempDir(t)
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	for name, str := range map[string]string{
+		"c.txt":     "TODO: c\n",
+		"dir/d.log": "TODO: d\n",
+	} {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(str))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(str)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, tmp, map[string]string{
+		"b.tar.gz":   buf.String(),
+		"broken.zip": "TODO: not zip\n",
+	})
+	zipPath, err := filepath.Abs(filepath.Join("testdata", "archive", "a.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		read bool
+		exts []string
+		exp  []string
+	}{
+		{
+			read: true,
+			exp:  []string{"a.zip!a.txt:1", "a.zip!dir/b.txt:2", "b.tar.gz!c.txt:1", "b.tar.gz!dir/d.log:1"},
+		},
+		{
+			read: true,
+			exts: []string{"txt"},
+			exp:  []string{"a.zip!a.txt:1", "a.zip!dir/b.txt:2", "b.tar.gz!c.txt:1"},
+		},
+		{
+			read: false,
+			exp:  []string{"broken.zip:1"},
+		},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetReadArchive(test.read); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetExtensions(test.exts...); err != nil {
+			t.Fatal(err)
+		}
+		var errs []error
+		if err := w.SetErrorHandler(func(err error) { errs = append(errs, err) }); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Collect(zipPath, tmp); err != nil {
+			t.Fatal(err)
+		}
+		trim := strings.NewReplacer(zipPath, "a.zip", tmp+string(filepath.Separator), "")
+		var out []string
+		for _, m := range w.Matches() {
+			out = append(out, fmt.Sprintf("%s:%d", trim.Replace(m.Path), m.Line))
+		}
+		sort.Strings(out)
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("read=%v exts=%q: out=%q, exp=%q", test.read, test.exts, out, test.exp)
+		}
+		if test.read {
+			if len(errs) != 1 {
+				t.Fatalf("errs=%v", errs)
+			}
+			if _, ok := errs[0].(*ExpectedError); !ok {
+				t.Errorf("broken archive is not expected error: %v", errs[0])
+			}
+		}
+	}
+}
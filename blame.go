@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Blame is the last commit that touched a matched line.
+type Blame struct {
+	Author string `json:"author"`
+	Commit string `json:"commit"`
+}
+
+// Blame returns the blame of the matched line by Walker.SetGitBlame, or nil.
+func (c *Context) Blame() *Blame {
+	return c.blame
+}
+
+// SetGitBlame attaches Blame to matched lines by "git blame" for files in
+// git repositories. git is run once for each file that has matched lines,
+// it is slow for many matches. files that are not in repositories have no
+// Blame. it is not applied to SetFS, standard input and archives.
+func (w *Walker) SetGitBlame(blame bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.gitBlame = blame
+	return nil
+}
+
+// blame attaches Blame to matched lines of f, lines are numbered from
+// lineBase. errors of git are ignored.
+func (w *Walker) blame(f *File, lineBase uint) {
+	if w.fsys != nil || w.readGzip && strings.HasSuffix(f.Path, gzipExt) {
+		return
+	}
+	var nums []uint
+	for _, c := range f.Contexts {
+		for _, m := range c.matches() {
+			nums = append(nums, m.Line().Num+1-lineBase)
+		}
+	}
+	if len(nums) == 0 {
+		return
+	}
+	blames, err := gitBlame(f.Path, nums)
+	if err != nil {
+		w.logger.Debugf("blame %s: %v", f.Path, err)
+		return
+	}
+	set := func(c *Context) {
+		if b, ok := blames[c.Line().Num+1-lineBase]; ok {
+			c.blame = &b
+		}
+	}
+	for _, c := range f.Contexts {
+		set(c)
+		for _, m := range c.merged {
+			set(m)
+		}
+	}
+}
+
+// gitBlame returns blames of lines nums of path by "git blame --porcelain".
+func gitBlame(path string, nums []uint) (map[uint]Blame, error) {
+	args := []string{"-C", filepath.Dir(path), "blame", "--porcelain"}
+	for _, n := range nums {
+		args = append(args, "-L", fmt.Sprintf("%d,%d", n, n))
+	}
+	args = append(args, "--", filepath.Base(path))
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git blame: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseBlame(out), nil
+}
+
+// parseBlame parses output of "git blame --porcelain". headers of a commit
+// are written only at the first line of the commit.
+func parseBlame(out []byte) map[uint]Blame {
+	blames := make(map[uint]Blame)
+	authors := make(map[string]string)
+	var commit string
+	var num uint
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			// content of the line ends an entry
+			blames[num] = Blame{Author: authors[commit], Commit: commit}
+			commit = ""
+		case commit == "":
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				continue
+			}
+			n, err := strconv.ParseUint(fields[2], 10, 0)
+			if err != nil {
+				continue
+			}
+			commit, num = fields[0], uint(n)
+		case strings.HasPrefix(line, "author "):
+			authors[commit] = strings.TrimPrefix(line, "author ")
+		}
+	}
+	return blames
+}
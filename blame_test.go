@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseBlame(t *testing.T) {
+	out := "aaaa 1 2 1\n" +
+		"author alice\n" +
+		"author-mail <alice@example.com>\n" +
+		"filename a.go\n" +
+		"\t// TODO: a\n" +
+		"bbbb 3 5 1\n" +
+		"author bob\n" +
+		"filename a.go\n" +
+		"\t// TODO: b\n" +
+		"aaaa 4 7 1\n" +
+		"\t// TODO: c\n"
+	exp := map[uint]Blame{
+		2: {Author: "alice", Commit: "aaaa"},
+		5: {Author: "bob", Commit: "bbbb"},
+		7: {Author: "alice", Commit: "aaaa"},
+	}
+	if blames := parseBlame([]byte(out)); !reflect.DeepEqual(blames, exp) {
+		t.Errorf("out=%v, exp=%v", blames, exp)
+	}
+}
+
+func TestSetGitBlame(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip(err)
+	}
+	dir := tempDir(t)
+	repo := filepath.Join(dir, "repo")
+	writeFiles(t, dir, map[string]string{
+		"repo/a.go":   "package a\n// TODO: a\n\n// TODO: b\n",
+		"outside.txt": "TODO: outside\n",
+	})
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+			"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir,
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return string(bytes.TrimSpace(out))
+	}
+	git("init", "-q")
+	git("add", "a.go")
+	git("commit", "-q", "-m", "init")
+	commit := git("rev-parse", "HEAD")
+
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMergeContext(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetGitBlame(true); err != nil {
+		t.Fatal(err)
+	}
+	fs := walk(t, w, filepath.Join(repo, "a.go"), filepath.Join(dir, "outside.txt"))
+	if len(fs) != 2 {
+		t.Fatalf("len(fs)=%d", len(fs))
+	}
+	exp := &Blame{Author: "alice", Commit: commit}
+	for _, f := range fs {
+		for _, c := range f.Contexts {
+			for _, m := range c.matches() {
+				b := m.Blame()
+				if filepath.Base(f.Path) == "outside.txt" {
+					if b != nil {
+						t.Errorf("%s: blamed outside of repository: %+v", f.Path, b)
+					}
+					continue
+				}
+				if !reflect.DeepEqual(b, exp) {
+					t.Errorf("%s:%d: out=%+v, exp=%+v", f.Path, m.Line().Num, b, exp)
+				}
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := FprintFilesJSON(buf, fs...); err != nil {
+		t.Fatal(err)
+	}
+	var jfs []struct {
+		Path     string
+		Contexts []struct {
+			Blame *Blame
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &jfs); err != nil {
+		t.Fatal(err)
+	}
+	for _, jf := range jfs {
+		if filepath.Base(jf.Path) == "a.go" && !reflect.DeepEqual(jf.Contexts[0].Blame, exp) {
+			t.Errorf("JSON blame=%+v, exp=%+v", jf.Contexts[0].Blame, exp)
+		}
+	}
+}
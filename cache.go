@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// scanCache is results of files in the last scans, entries are valid while
+// modification time and size of files are not changed.
+type scanCache struct {
+	mu      sync.Mutex
+	key     string
+	entries map[string]*cacheEntry
+}
+
+// cacheData is the content of the cache file encoded by gob.
+type cacheData struct {
+	// options of matching, entries of other options are discarded.
+	Key     string
+	Entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	ModTime   time.Time
+	Size      int64
+	Count     int
+	Truncated bool
+	Contexts  []*cacheContext
+
+	// sha256 of content for SetDedupByContent, nil if not computed.
+	Sum []byte
+}
+
+type cacheContext struct {
+	Index       int
+	Lines       []*Line
+	Loc         []int
+	Patterns    []int
+	Spans       [][]int
+	Annotation  Annotation
+	Enclosing   *Line
+	Blame       *Blame
+	Merged      []*cacheContext
+	MergedIndex []int
+}
+
+// SetCache reuses results of unchanged files from the cache file at path,
+// files are compared by modification time and size. the cache is updated
+// at the end of wait. entries are discarded if patterns or options of
+// matching are changed. empty path disables the cache as default.
+// files of SetMatchers, standard input and archives are not cached, and the
+// cache is not used with SetDecoder.
+func (w *Walker) SetCache(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.cachePath = path
+	return nil
+}
+
+// cacheKey returns options that change results of files.
+func (w *Walker) cacheKey() string {
+	var annotation, header string
+	if w.annotation != nil {
+		annotation = w.annotation.String()
+	}
+	if w.header != nil {
+		header = w.header.String()
+	}
+	return fmt.Sprintf("%q %q %v %v %v %v %v %d %d %v %v %v %d %q %d %v %v %d %d %d %d %q %q %v %v %v",
+		w.pats, w.engine, w.ignoreCase, w.literal, w.wholeWord, w.fullLine, w.invert,
+		w.nbefore, w.nafter, w.merge, w.countOnly, w.namesOnly, w.lineBase,
+		w.binary, w.maxLine, w.splitCR, w.multiline, w.mlMaxBytes,
+		w.maxFileMatches, w.headLines, w.tailLines, annotation, header,
+		w.hl != nil, w.gitBlame, w.readGzip)
+}
+
+// loadCache reads the cache file, entries are empty if the file does not
+// exist or options are changed.
+func (w *Walker) loadCache() (*scanCache, error) {
+	c := &scanCache{key: w.cacheKey(), entries: make(map[string]*cacheEntry)}
+	f, err := os.Open(w.cachePath)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var data cacheData
+	if err := gob.NewDecoder(f).Decode(&data); err != nil {
+		return nil, fmt.Errorf("cache %s: %v", w.cachePath, err)
+	}
+	if data.Key == c.key && data.Entries != nil {
+		c.entries = data.Entries
+	}
+	return c, nil
+}
+
+// save writes c to path.
+func (c *scanCache) save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	err = gob.NewEncoder(f).Encode(&cacheData{Key: c.key, Entries: c.entries})
+	c.mu.Unlock()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// get returns cached result of path, or nil if not cached or changed.
+func (c *scanCache) get(path string, fi os.FileInfo) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[path]
+	if e == nil || !e.ModTime.Equal(fi.ModTime()) || e.Size != fi.Size() {
+		return nil
+	}
+	return e
+}
+
+func (c *scanCache) put(path string, fi os.FileInfo, f *File) {
+	e := &cacheEntry{
+		ModTime:   fi.ModTime(),
+		Size:      fi.Size(),
+		Count:     f.count,
+		Truncated: f.truncated,
+		Contexts:  make([]*cacheContext, len(f.Contexts)),
+		Sum:       f.sum,
+	}
+	for i, ctx := range f.Contexts {
+		e.Contexts[i] = newCacheContext(ctx)
+	}
+	c.mu.Lock()
+	c.entries[path] = e
+	c.mu.Unlock()
+}
+
+func newCacheContext(c *Context) *cacheContext {
+	cc := &cacheContext{
+		Index:       c.index,
+		Lines:       c.lines,
+		Loc:         c.loc,
+		Patterns:    c.patterns,
+		Spans:       c.spans,
+		Annotation:  c.annotation,
+		Enclosing:   c.enclosing,
+		Blame:       c.blame,
+		MergedIndex: c.mergedIndex,
+	}
+	for _, m := range c.merged {
+		cc.Merged = append(cc.Merged, newCacheContext(m))
+	}
+	return cc
+}
+
+// file returns new File of e, options of printing are taken from w.
+func (e *cacheEntry) file(w *Walker, path string) *File {
+	f := &File{
+		Path:      path,
+		Contexts:  make([]*Context, len(e.Contexts)),
+		count:     e.Count,
+		truncated: e.Truncated,
+	}
+	for i, cc := range e.Contexts {
+		f.Contexts[i] = cc.context(w)
+	}
+	return f
+}
+
+func (cc *cacheContext) context(w *Walker) *Context {
+	c := &Context{
+		index:       cc.Index,
+		lines:       cc.Lines,
+		loc:         cc.Loc,
+		patterns:    cc.Patterns,
+		hl:          w.hl,
+		spans:       cc.Spans,
+		trimIndent:  w.trimIndent,
+		annotation:  cc.Annotation,
+		enclosing:   cc.Enclosing,
+		blame:       cc.Blame,
+		mergedIndex: cc.MergedIndex,
+	}
+	for _, m := range cc.Merged {
+		c.merged = append(c.merged, m.context(w))
+	}
+	return c
+}
@@ -0,0 +1,30 @@
+package main
+
+import "strings"
+
+// NormalizeText trims s and collapses white spaces to a space.
+func NormalizeText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// FindDuplicates groups matched lines of fs by text normalized by
+// normalize, and returns groups that have several lines. nil normalize is
+// NormalizeText.
+func FindDuplicates(fs []*File, normalize func(string) string) map[string][]Match {
+	if normalize == nil {
+		normalize = NormalizeText
+	}
+	groups := make(map[string][]Match)
+	for _, f := range fs {
+		for _, m := range f.Matches() {
+			key := normalize(m.Text)
+			groups[key] = append(groups[key], m)
+		}
+	}
+	for key, ms := range groups {
+		if len(ms) < 2 {
+			delete(groups, key)
+		}
+	}
+	return groups
+}
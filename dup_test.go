@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	a := readString(t, fr, "// TODO: refactor this\n// TODO: a\n")
+	a.Path = "a.go"
+	b := readString(t, fr, "x\n\t//  TODO:   refactor this \n// TODO: A\n")
+	b.Path = "b.go"
+
+	out := FindDuplicates([]*File{a, b}, nil)
+	exp := map[string][]Match{
+		"// TODO: refactor this": {
+			{Path: "a.go", Line: 1, Text: "// TODO: refactor this"},
+			{Path: "b.go", Line: 2, Text: "\t//  TODO:   refactor this "},
+		},
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+
+	out = FindDuplicates([]*File{a, b}, func(s string) string {
+		return strings.ToLower(NormalizeText(s))
+	})
+	if len(out) != 2 || len(out["// todo: a"]) != 2 {
+		t.Errorf("out=%q", out)
+	}
+}
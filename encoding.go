@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// SetDecoder sets decode that returns reader decodes r to UTF-8, for files
+// of an encoding other than UTF-8 and UTF-16 with BOM. it is called for
+// each files concurrently. e.g. with golang.org/x/text/encoding/japanese,
+//
+//	func(r io.Reader) io.Reader { return japanese.ShiftJIS.NewDecoder().Reader(r) }
+//
+// nil is default, files that are not UTF-8 are skipped.
+func (w *Walker) SetDecoder(decode func(r io.Reader) io.Reader) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.decode = decode
+	return nil
+}
+
+// newTextReader returns reader that decodes to UTF-8 if r starts with
+// a BOM of UTF-16, otherwise contents of r are not changed.
+func newTextReader(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	bom, _ := br.Peek(2)
+	switch {
+	case bytes.Equal(bom, bomUTF16LE):
+		br.Discard(len(bomUTF16LE))
+		return &utf16Reader{r: br, order: binary.LittleEndian}
+	case bytes.Equal(bom, bomUTF16BE):
+		br.Discard(len(bomUTF16BE))
+		return &utf16Reader{r: br, order: binary.BigEndian}
+	}
+	return br
+}
+
+// utf16Reader decodes UTF-16 to UTF-8.
+// invalid sequences are decoded to utf8.RuneError.
+type utf16Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   []byte // decoded and not read yet
+	err   error
+
+	// pending unit that read after a high surrogate
+	pending    rune
+	hasPending bool
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		if u.err != nil {
+			return 0, u.err
+		}
+		u.fill()
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+// number of units decoded at once.
+const utf16ChunkUnits = 2048
+
+func (u *utf16Reader) fill() {
+	for i := 0; i != utf16ChunkUnits; i++ {
+		r1, err := u.unit()
+		if err != nil {
+			u.err = err
+			return
+		}
+		if utf16.IsSurrogate(r1) {
+			r2, err := u.unit()
+			if err != nil {
+				u.buf = utf8.AppendRune(u.buf, utf8.RuneError)
+				u.err = err
+				return
+			}
+			if dec := utf16.DecodeRune(r1, r2); dec != utf8.RuneError {
+				u.buf = utf8.AppendRune(u.buf, dec)
+				continue
+			}
+			// r2 is not a pair of r1
+			u.buf = utf8.AppendRune(u.buf, utf8.RuneError)
+			u.pending, u.hasPending = r2, true
+			continue
+		}
+		u.buf = utf8.AppendRune(u.buf, r1)
+	}
+}
+
+// unit reads a UTF-16 code unit.
+func (u *utf16Reader) unit() (rune, error) {
+	if u.hasPending {
+		u.hasPending = false
+		return u.pending, nil
+	}
+	var b [2]byte
+	_, err := io.ReadFull(u.r, b[:])
+	switch err {
+	case nil:
+		return rune(u.order.Uint16(b[:])), nil
+	case io.ErrUnexpectedEOF:
+		// odd length
+		u.buf = utf8.AppendRune(u.buf, utf8.RuneError)
+		return 0, io.EOF
+	}
+	return 0, err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReadUTF16(t *testing.T) {
+	exp := "2:// TODO: 日本語 \U0001F600\n"
+	for _, name := range []string{"utf16le.txt", "utf16be.txt"} {
+		fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+		f, err := fr.ReadFile(filepath.Join("testdata", "encoding", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Contexts) != 1 {
+			t.Fatalf("%s: contexts=%d, exp 1", name, len(f.Contexts))
+		}
+		if out := f.Contexts[0].String(); out != exp {
+			t.Errorf("%s: out=%q, exp=%q", name, out, exp)
+		}
+	}
+}
+
+func TestNewTextReader(t *testing.T) {
+	tests := []struct {
+		in, exp string
+	}{
+		{in: "no bom", exp: "no bom"},
+		{in: "", exp: ""},
+		{in: "\xff\xfea\x00", exp: "a"},
+		{in: "\xfe\xff\x00a", exp: "a"},
+		// odd length
+		{in: "\xff\xfea\x00b", exp: "a�"},
+		// lone surrogate
+		{in: "\xff\xfe\x00\xd8a\x00", exp: "�a"},
+	}
+	for _, test := range tests {
+		b, err := ioutil.ReadAll(newTextReader(strings.NewReader(test.in)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := string(b); out != test.exp {
+			t.Errorf("in=%q: out=%q, exp=%q", test.in, out, test.exp)
+		}
+	}
+}
+
+// sjisReader decodes Shift_JIS of "日本語" and ASCII for tests.
+type sjisReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+var sjisTable = map[string]string{
+	"\x93\xfa": "日",
+	"\x96{":    "本",
+	"\x8c\xea": "語",
+}
+
+func (s *sjisReader) Read(p []byte) (int, error) {
+	if s.buf == nil {
+		b, err := ioutil.ReadAll(s.r)
+		if err != nil {
+			return 0, err
+		}
+		s.buf = []byte{}
+		for i := 0; i < len(b); i++ {
+			if b[i] < 0x80 || i+1 == len(b) {
+				s.buf = append(s.buf, b[i])
+				continue
+			}
+			s.buf = append(s.buf, sjisTable[string(b[i:i+2])]...)
+			i++
+		}
+	}
+	if len(s.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func TestSetDecoder(t *testing.T) {
+	dir := filepath.Join("testdata", "encoding")
+	path, err := filepath.Abs(filepath.Join(dir, "sjis.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decode := range []bool{false, true} {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if decode {
+			err := w.SetDecoder(func(r io.Reader) io.Reader { return &sjisReader{r: r} })
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		fs := walk(t, w, path)
+		if !decode {
+			if len(fs) != 0 || w.Stats().SkippedInvalidText != 1 {
+				t.Errorf("not skipped without decoder: %d files", len(fs))
+			}
+			continue
+		}
+		if out := matchedLines(fs); !reflect.DeepEqual(out, []string{"// TODO: 日本語"}) {
+			t.Errorf("out=%q", out)
+		}
+	}
+}
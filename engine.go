@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// names of regexp engines for SetRegexpEngine.
+const (
+	EngineRegexp  = "regexp"  // standard regexp, RE2 syntax
+	EngineRegexp2 = "regexp2" // github.com/dlclark/regexp2, built with tag "regexp2"
+)
+
+// engineOptions are options of matching that engines apply to a pattern.
+type engineOptions struct {
+	// match whole words, word characters are Unicode letters, digits and
+	// underscore.
+	wholeWord bool
+
+	// limit of a match of backtracking engines, 0 is no limit.
+	timeout time.Duration
+}
+
+// regexpEngines compile a pattern to a matcher, engines other than
+// EngineRegexp are registered by init of files built with tags.
+var regexpEngines = map[string]func(pat string, opts engineOptions) (IndexMatcher, error){
+	EngineRegexp: func(pat string, opts engineOptions) (IndexMatcher, error) {
+		if opts.wholeWord {
+			return compileWordRegexp(pat)
+		}
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, err
+		}
+		return NewRegexpMatcher(re), nil
+	},
+}
+
+// SetRegexpEngine selects the engine that compiles regexps, default is
+// EngineRegexp. EngineRegexp2 supports lookaround and backreferences, e.g.
+// "(?<!//\s)TODO", but it backtracks and is slower than EngineRegexp that
+// matches in linear time. it is available only if built with tag "regexp2".
+// a match of EngineRegexp2 is limited by SetFileTimeout, then the line is
+// not matched and the file is skipped with ErrTimeout. without the timeout
+// a pattern of catastrophic backtracking may not return.
+func (w *Walker) SetRegexpEngine(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if _, ok := regexpEngines[name]; !ok {
+		return fmt.Errorf("SetRegexpEngine: unavailable engine %q", name)
+	}
+	old := w.engine
+	w.engine = name
+	if err := w.recompile(); err != nil {
+		w.engine = old
+		return err
+	}
+	return nil
+}
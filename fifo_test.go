@@ -0,0 +1,78 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIncludeNonRegular(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{"a.txt": "TODO: a\n"})
+	fifo := filepath.Join(tmp, "fifo")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skip(err)
+	}
+
+	// skipped by default, not blocked
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if out := relPaths(t, tmp, walk(t, w, tmp)); !reflect.DeepEqual(out, []string{"a.txt"}) {
+		t.Errorf("out=%q", out)
+	}
+
+	// no writer, not blocked
+	w = NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetIncludeNonRegular(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetFileTimeout(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	withTimeout(t, 5*time.Second, func() {
+		if out := matchedLines(walk(t, w, tmp)); !reflect.DeepEqual(out, []string{"TODO: a"}) {
+			t.Errorf("out=%q", out)
+		}
+	})
+
+	// written content is kept while a reader is opened
+	reader, err := os.OpenFile(fifo, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writer.WriteString("TODO: fifo\n")
+	writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetIncludeNonRegular(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetFileTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fs := walk(t, w, tmp)
+	if out := matchedLines(fs); !reflect.DeepEqual(out, []string{"TODO: a", "TODO: fifo"}) {
+		t.Errorf("out=%q", out)
+	}
+}
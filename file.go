@@ -2,16 +2,37 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 	"unicode/utf8"
 )
 
 var ErrTooManyLines = errors.New("too many lines")
 var ErrUnavailableText = errors.New("unavailable encoding")
+var ErrTimeout = errors.New("read timeout")
+
+// maxLineNum is the last number of lines, math.MaxUint.
+const maxLineNum = ^uint(0)
+
+// modes of binary file detection.
+const (
+	BinaryUTF8    = "utf8"    // skip files that contain invalid UTF-8
+	BinaryNulByte = "nulbyte" // skip files that contain NUL in the head
+	BinaryOff     = "off"     // read all files as text
+)
+
+// size of the head for BinaryNulByte.
+const binaryProbeSize = 8000
 
 type ExpectedError struct {
 	path string
@@ -22,64 +43,595 @@ func (e *ExpectedError) Error() string {
 	return fmt.Sprintf("ExpectedError:%s:%v", e.path, e.err)
 }
 
+// Unwrap returns the cause for errors.Is and errors.As.
+func (e *ExpectedError) Unwrap() error {
+	return e.err
+}
+
 type File struct {
 	Path     string
 	Contexts []*Context
+
+	// number of matched lines.
+	count int
+
+	// lines are not read to the end, or matched lines are dropped by
+	// limits.
+	truncated bool
+
+	// listed by dry run without reading.
+	candidate bool
+
+	// the sent path that the file is found from.
+	root string
+
+	// sha256 of content if Walker.SetDedupByContent.
+	sum []byte
+}
+
+// Count returns number of matched lines.
+func (f *File) Count() int {
+	return f.count
+}
+
+// Truncated reports whether f is not fully scanned by limits, e.g.
+// SetMaxMatchesPerFile, SetMaxMatches and SetHeadLines.
+// a file that reached SetFileTimeout is skipped instead.
+func (f *File) Truncated() bool {
+	return f.truncated
+}
+
+// Root returns the path that f is found from, it is the nearest directory
+// of Walker.SendPath that contains f, or f.Path if f is sent as a file.
+// it is empty for files that are not from Walker.
+func (f *File) Root() string {
+	return f.root
+}
+
+// Candidate reports whether f is listed by SetDryRun without reading.
+func (f *File) Candidate() bool {
+	return f.candidate
+}
+
+// truncate drops matched lines after the first n, nafter lines after the
+// last kept match are kept as the context.
+func (f *File) truncate(n, nafter int) {
+	if f.count <= n {
+		return
+	}
+	f.count = n
+	f.truncated = true
+	for i, c := range f.Contexts {
+		if m := 1 + len(c.merged); m <= n {
+			n -= m
+			continue
+		}
+		if n == 0 {
+			f.Contexts = f.Contexts[:i]
+			return
+		}
+		// cut merged context after the last kept match
+		t := *c
+		t.merged = c.merged[:n-1]
+		t.mergedIndex = c.mergedIndex[:n-1]
+		last := c.index
+		if len(t.mergedIndex) != 0 {
+			last = t.mergedIndex[len(t.mergedIndex)-1]
+		}
+		if end := last + 1 + nafter; end < len(c.lines) {
+			t.lines = c.lines[:end]
+		}
+		f.Contexts = append(f.Contexts[:i], &t)
+		return
+	}
+}
+
+// FprintCount writes "path: N" that N is number of matched lines.
+func (f *File) FprintCount(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "%s: %d\n", f.Path, f.count)
+	return err
+}
+
+// FprintVerbose writes lines of contexts as "path:line:text", and other
+// lines of contexts as "path:line-text". the enclosing line is written as
+// "path:line=text" before the context. "path:[truncated]" is written at
+// last if f is truncated.
+func (f *File) FprintVerbose(writer io.Writer) error {
+	for _, c := range f.Contexts {
+		if l := c.enclosingLine(); l != nil {
+			if _, err := fmt.Fprintf(writer, "%s:%d=%s\n", f.Path, l.Num, c.text(l)); err != nil {
+				return err
+			}
+		}
+		for i, l := range c.lines {
+			var err error
+			if m := c.matchAt(i); m != nil {
+				_, err = fmt.Fprintf(writer, "%s:%d:%s\n", f.Path, l.Num, m.matchedText())
+			} else {
+				_, err = fmt.Fprintf(writer, "%s:%d-%s\n", f.Path, l.Num, c.text(l))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if f.truncated {
+		_, err := fmt.Fprintf(writer, "%s:[truncated]\n", f.Path)
+		return err
+	}
+	return nil
+}
+
+// Match is a matched line without context.
+type Match struct {
+	Path string
+	Line uint
+	Text string
+}
+
+// String returns "path:line:text".
+func (m Match) String() string {
+	return fmt.Sprintf("%s:%d:%s", m.Path, m.Line, m.Text)
+}
+
+// Matches returns matched lines of f, including merged contexts.
+func (f *File) Matches() []Match {
+	var ms []Match
+	for _, c := range f.Contexts {
+		for _, m := range c.matches() {
+			l := m.Line()
+			ms = append(ms, Match{Path: f.Path, Line: l.Num, Text: l.Str})
+		}
+	}
+	return ms
+}
+
+// SortFiles sorts fs by path, compared by each elements of the path.
+func SortFiles(fs []*File) {
+	sort.SliceStable(fs, func(i, j int) bool {
+		return lessPath(fs[i].Path, fs[j].Path)
+	})
+}
+
+// SortContexts sorts cs by less, the order of equal contexts is kept.
+// before and after lines are moved with the matched line.
+func SortContexts(cs []*Context, less func(a, b *Context) bool) {
+	sort.SliceStable(cs, func(i, j int) bool {
+		return less(cs[i], cs[j])
+	})
+}
+
+// lessPath compares paths by each elements, "a/b" is less than "a-b".
+func lessPath(a, b string) bool {
+	as := strings.Split(filepath.ToSlash(a), "/")
+	bs := strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
 }
 
 type Context struct {
 	index int
 	lines []*Line
 	loc   []int
+
+	// indexes of matched patterns.
+	patterns []int
+
+	// for highlight matched substrings.
+	hl    *highlight
+	spans [][]int
+
+	// print lines without leading white spaces.
+	trimIndent bool
+
+	// metadata of the matched line.
+	annotation Annotation
+
+	// the nearest line before the matched line that matched a header
+	// pattern, e.g. a function, nil if not found.
+	enclosing *Line
+
+	// the last commit of the matched line, nil if not blamed.
+	blame *Blame
+
+	// matched contexts that merged into this, and indexes of their
+	// matched lines.
+	merged      []*Context
+	mergedIndex []int
+}
+
+// Annotation is metadata of a matched line, e.g. "TODO(alice): [P1] x".
+type Annotation struct {
+	Author   string `json:"author,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// parseAnnotation extracts capture groups named "author" and "priority" of
+// re from s. fields are empty if not matched.
+func parseAnnotation(re *regexp.Regexp, s string) Annotation {
+	var a Annotation
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return a
+	}
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "author":
+			a.Author = m[i]
+		case "priority":
+			a.Priority = m[i]
+		}
+	}
+	return a
+}
+
+// Annotation returns metadata of the matched line.
+func (c *Context) Annotation() Annotation {
+	return c.annotation
+}
+
+// Enclosing returns the nearest line before the matched line that matched
+// the pattern of Walker.SetShowEnclosing, or nil.
+func (c *Context) Enclosing() *Line {
+	return c.enclosing
+}
+
+// enclosingLine returns the enclosing line that is not in c.lines for
+// printing, or nil.
+func (c *Context) enclosingLine() *Line {
+	if start, _ := c.Range(); c.enclosing == nil || c.enclosing.Num >= start {
+		return nil
+	}
+	return c.enclosing
+}
+
+// highlight is delimiters of matched substrings.
+type highlight struct {
+	start, end string
+}
+
+// nameContext returns a context of line 0 that is name if name matched ms,
+// otherwise nil. it is for Walker.SetMatchPath.
+func nameContext(ms []Matcher, name string, hl *highlight) *Context {
+	c := &Context{lines: []*Line{{Num: 0, Str: name}}}
+	for i, m := range ms {
+		loc := findIndex(m, name)
+		if loc == nil {
+			continue
+		}
+		if c.loc == nil {
+			c.loc = loc
+		}
+		c.patterns = append(c.patterns, i)
+	}
+	if c.loc == nil {
+		return nil
+	}
+	if hl != nil {
+		c.hl = hl
+		c.spans = matchSpans(ms, name)
+	}
+	return c
+}
+
+// matchSpans returns sorted and merged locations of all matched substrings.
+func matchSpans(ms []Matcher, s string) [][]int {
+	var spans [][]int
+	for _, m := range ms {
+		im, ok := m.(IndexMatcher)
+		if !ok {
+			continue
+		}
+		spans = append(spans, im.FindAllIndex(s, -1)...)
+	}
+	return mergeSpans(spans)
+}
+
+// mergeSpans sorts and merges overlapped spans, and drops empty spans.
+func mergeSpans(spans [][]int) [][]int {
+	nonempty := spans[:0]
+	for _, span := range spans {
+		if span[0] != span[1] {
+			nonempty = append(nonempty, span)
+		}
+	}
+	spans = nonempty
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	merged := spans[:0]
+	for _, span := range spans {
+		if n := len(merged); n != 0 && span[0] <= merged[n-1][1] {
+			if span[1] > merged[n-1][1] {
+				merged[n-1][1] = span[1]
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// matchedText returns the matched line, with highlight if enabled.
+func (c *Context) matchedText() string {
+	str := c.lines[c.index].Str
+	start := 0
+	if c.trimIndent {
+		start = len(str) - len(strings.TrimLeft(str, " \t"))
+	}
+	if c.hl == nil || len(c.spans) == 0 {
+		return str[start:]
+	}
+	var s string
+	last := start
+	for _, span := range c.spans {
+		if span[0] < start && span[1] <= start {
+			continue
+		}
+		from := span[0]
+		if from < start {
+			from = start
+		}
+		s += str[last:from] + c.hl.start + str[from:span[1]] + c.hl.end
+		last = span[1]
+	}
+	return s + str[last:]
+}
+
+// text returns l for printing.
+func (c *Context) text(l *Line) string {
+	if c.trimIndent {
+		return strings.TrimLeft(l.Str, " \t")
+	}
+	return l.Str
+}
+
+// Line returns the matched line.
+func (c *Context) Line() *Line {
+	return c.lines[c.index]
+}
+
+// Before returns lines before the matched line.
+func (c *Context) Before() []*Line {
+	return c.lines[:c.index:c.index]
+}
+
+// After returns lines after the matched line.
+func (c *Context) After() []*Line {
+	return c.lines[c.index+1:]
+}
+
+// Range returns numbers of the first and the last lines of c, including
+// before and after lines.
+func (c *Context) Range() (start, end uint) {
+	return c.lines[0].Num, c.lines[len(c.lines)-1].Num
+}
+
+// Patterns returns indexes of patterns that matched the line.
+func (c *Context) Patterns() []int {
+	return c.patterns
+}
+
+// MatchedPattern returns index of the first pattern that matched the line,
+// it is 0 for a single pattern, and -1 for lines of SetInvertMatch.
+func (c *Context) MatchedPattern() int {
+	if len(c.patterns) == 0 {
+		return -1
+	}
+	return c.patterns[0]
 }
 
 func (c *Context) String() string {
 	var s string
+	if l := c.enclosingLine(); l != nil {
+		s += fmt.Sprintf("%d=%s\n", l.Num, c.text(l))
+	}
 	for i, l := range c.lines {
-		if i == c.index {
-			s += fmt.Sprintf("%d:%s\n", l.Num, l.Str)
+		if m := c.matchAt(i); m != nil {
+			s += fmt.Sprintf("%d:%s\n", l.Num, m.matchedText())
 			continue
 		}
-		s += fmt.Sprintf("%d-%s\n", l.Num, l.Str)
+		s += fmt.Sprintf("%d-%s\n", l.Num, c.text(l))
+	}
+	return s
+}
+
+// matchedSubstring returns the first matched substring of the matched line,
+// empty if it is unknown.
+func (c *Context) matchedSubstring() string {
+	str := c.lines[c.index].Str
+	if len(c.loc) != 2 || c.loc[0] < 0 || c.loc[1] > len(str) || c.loc[0] >= c.loc[1] {
+		return ""
+	}
+	return str[c.loc[0]:c.loc[1]]
+}
+
+// Offset returns byte offset of first match in the matched line.
+func (c *Context) Offset() int {
+	if len(c.loc) != 2 {
+		return 0
+	}
+	return c.loc[0]
+}
+
+// Column returns 1-based rune column of first match in the matched line.
+func (c *Context) Column() int {
+	return utf8.RuneCountInString(c.lines[c.index].Str[:c.Offset()]) + 1
+}
+
+// TaggedString is like String but matched line is annotated with tags of
+// matched patterns.
+func (c *Context) TaggedString(tags []string) string {
+	var s string
+	if l := c.enclosingLine(); l != nil {
+		s += fmt.Sprintf("%d=%s\n", l.Num, c.text(l))
+	}
+	for i, l := range c.lines {
+		if m := c.matchAt(i); m != nil {
+			var ts []string
+			for _, p := range m.patterns {
+				if p < len(tags) {
+					ts = append(ts, tags[p])
+				}
+			}
+			s += fmt.Sprintf("%d:[%s]:%s\n", l.Num, strings.Join(ts, ","), m.matchedText())
+			continue
+		}
+		s += fmt.Sprintf("%d-%s\n", l.Num, c.text(l))
 	}
 	return s
 }
 
 type Line struct {
-	Num uint
-	Str string
+	Num uint   `json:"num"`
+	Str string `json:"str"`
 }
 
 // remove?
+// linesBuffer is a bounded window of the last capa lines, it is a ring
+// buffer that does not allocate on push and del.
 type linesBuffer struct {
-	capa int
 	buf  []*Line
+	head int // index of the oldest line
+	n    int
+
+	// dropped lines for reuse, they are not referenced from contexts.
+	free []*Line
 }
 
-func newLinesBuffer(capa int) *linesBuffer {
+// newLinesBuffer returns linesBuffer of capa, capa must be 1 or more.
+func newLinesBuffer(capa int) (*linesBuffer, error) {
+	if capa < 1 {
+		return nil, errors.New("newLinesBuffer: capacity must be 1 or more")
+	}
 	return &linesBuffer{
-		capa: capa,
-		buf:  make([]*Line, 0, capa),
+		buf:  make([]*Line, capa),
+		free: make([]*Line, 0, capa),
+	}, nil
+}
+
+func (lb *linesBuffer) len() int { return lb.n }
+func (lb *linesBuffer) reset() {
+	for lb.del() {
+	}
+	lb.head = 0
+}
+
+// del drops the oldest line, it reports false if lb is empty.
+func (lb *linesBuffer) del() bool {
+	if lb.n == 0 {
+		return false
 	}
+	lb.free = append(lb.free, lb.buf[lb.head])
+	lb.buf[lb.head] = nil
+	lb.head = (lb.head + 1) % len(lb.buf)
+	lb.n--
+	return true
 }
-func (lb *linesBuffer) len() int { return len(lb.buf) }
-func (lb *linesBuffer) reset()   { lb.buf = lb.buf[:0] }
 
-// believe not out of bound
-func (lb *linesBuffer) del() { lb.buf = lb.buf[1:] }
+// push appends l, the oldest line is dropped if lb is full.
 func (lb *linesBuffer) push(l *Line) {
-	if lb.capa == len(lb.buf) {
-		lb.buf = append(lb.buf[1:], l)
+	if lb.n == len(lb.buf) {
+		lb.del()
+	}
+	lb.buf[(lb.head+lb.n)%len(lb.buf)] = l
+	lb.n++
+}
+
+// newLine returns l that reused dropped line if available.
+func (lb *linesBuffer) newLine(num uint, str string) *Line {
+	n := len(lb.free)
+	if n == 0 {
+		return &Line{num, str}
+	}
+	l := lb.free[n-1]
+	lb.free = lb.free[:n-1]
+	l.Num, l.Str = num, str
+	return l
+}
+
+// appendTo appends all lines to dst from the oldest and clears lb, lines
+// are not reused after that.
+func (lb *linesBuffer) appendTo(dst []*Line) []*Line {
+	for ; lb.n != 0; lb.n-- {
+		dst = append(dst, lb.buf[lb.head])
+		lb.buf[lb.head] = nil
+		lb.head = (lb.head + 1) % len(lb.buf)
+	}
+	lb.head = 0
+	return dst
+}
+
+type tailLine struct {
+	num          uint
+	str          string
+	offset, next int
+}
+
+// tailBuffer keeps last capa lines.
+type tailBuffer struct {
+	capa    int
+	buf     []tailLine
+	head    int
+	dropped bool
+}
+
+func newTailBuffer(capa int) *tailBuffer {
+	return &tailBuffer{
+		capa: capa,
+		buf:  make([]tailLine, 0, capa),
+	}
+}
+
+func (tb *tailBuffer) reset() {
+	tb.buf = tb.buf[:0]
+	tb.head = 0
+	tb.dropped = false
+}
+
+func (tb *tailBuffer) push(l tailLine) {
+	if len(tb.buf) < tb.capa {
+		tb.buf = append(tb.buf, l)
 		return
 	}
-	lb.buf = append(lb.buf, l)
+	tb.buf[tb.head] = l
+	tb.head = (tb.head + 1) % tb.capa
+	tb.dropped = true
 }
 
-func (lb *linesBuffer) popAll() []*Line {
-	ls := make([]*Line, lb.len())
-	copy(ls, lb.buf)
-	lb.buf = lb.buf[:0]
-	return ls
+// lines returns kept lines in order.
+func (tb *tailBuffer) lines() []tailLine {
+	return append(append(make([]tailLine, 0, len(tb.buf)), tb.buf[tb.head:]...), tb.buf[:tb.head]...)
+}
+
+// scanLines is like bufio.ScanLines but also splits at bare "\r".
+// line endings are "\n", "\r\n" and "\r".
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// "\r" is the last, wait for "\n"
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
 }
 
 // TODO: fix
@@ -94,16 +646,101 @@ type FileReader struct {
 	nbefore int
 	nafter  int
 
-	i    uint   // current number of lines
-	loc  []int  // location of matched
-	text string // scanned result
-	re   *regexp.Regexp
+	i        uint   // current number of lines
+	lineBase uint   // number of the first line in results, 0 or 1
+	loc      []int  // location of matched
+	patterns []int  // indexes of matched patterns
+	text     string // scanned result
+	ms       []Matcher
+	invert   bool // select non-matching lines
+	merge    bool // merge adjacent contexts
+
+	// mode of binary file detection, empty is BinaryUTF8.
+	binary string
+
+	// decode contents to UTF-8, nil is newTextReader.
+	decode func(io.Reader) io.Reader
+
+	// max bytes of a line, 0 is bufio.MaxScanTokenSize.
+	maxLineSize int
+
+	// split lines at bare "\r" too.
+	splitCR bool
+
+	// match patterns over whole text, and select lines that overlapped
+	// matches.
+	multiline bool
+	wholeText bool      // current file is matched over whole text
+	mlSpans   [][][]int // remaining locations of matches for each patterns
+	lineSpans [][]int   // locations of matches in current line
+	offset    int       // offset of current line in whole text
+	next      int       // offset of next line
+
+	// files larger than maxWholeText bytes are matched line by line in
+	// multiline mode, 0 is no limit.
+	maxWholeText int64
+	logger       Logger
+
+	// stop reading after maxMatches matched lines and their contexts,
+	// 0 is no limit. lines are counted to the end if countOnly.
+	maxMatches int
+	last       uint // number of the last matched line
+	truncated  bool
+
+	// read only first headLines lines and last tailLines lines of them,
+	// 0 is no limit.
+	headLines int
+	tailLines int
+	tail      *tailBuffer
+
+	// added to numbers of lines, only tests set it to reach maxLineNum.
+	lineOffset uint
+
+	// called for each matched lines of path as read, nil is disabled.
+	path    string
+	onMatch func(path string, line uint, text string)
+
+	// abandon reading after deadline, zero is no deadline.
+	deadline time.Time
+
+	// extract Annotation from matched lines, nil is disabled.
+	annotation *regexp.Regexp
+
+	// set Context.enclosing by the last line that matched header, nil is
+	// disabled.
+	header     *regexp.Regexp
+	lastHeader *Line
+
+	// stop at the first matched line, without before and after lines.
+	namesOnly bool
+
+	// set Context.trimIndent.
+	trimIndent bool
+
+	// count matched lines only, without contexts.
+	countOnly bool
+	count     int
+	hl        *highlight
 
 	// for apppend *FileReader.c to *FileReader.cs
 	appendFunc func()
 }
 
 func NewFileReader(re *regexp.Regexp, nbefore int, nafter int) *FileReader {
+	return NewMultiFileReader([]*regexp.Regexp{re}, nbefore, nafter)
+}
+
+// NewMultiFileReader is like NewFileReader but matches any of res.
+func NewMultiFileReader(res []*regexp.Regexp, nbefore int, nafter int) *FileReader {
+	ms := make([]Matcher, len(res))
+	for i, re := range res {
+		ms[i] = NewRegexpMatcher(re)
+	}
+	return NewMatcherFileReader(ms, nbefore, nafter)
+}
+
+// NewMatcherFileReader is like NewMultiFileReader but matches by ms.
+func NewMatcherFileReader(ms []Matcher, nbefore int, nafter int) *FileReader {
 	if nbefore < 0 {
 		nbefore = 0
 	}
@@ -114,12 +751,18 @@ func NewFileReader(re *regexp.Regexp, nbefore int, nafter int) *FileReader {
 	if nbefore > max || nafter > max {
 		panic("NewFileReader: out of bound")
 	}
+	lb, err := newLinesBuffer(nbefore + 1 + nafter)
+	if err != nil {
+		panic(err)
+	}
 	fr := &FileReader{
-		lb:      newLinesBuffer(nbefore + 1 + nafter),
-		c:       &Context{},
-		nbefore: nbefore,
-		nafter:  nafter,
-		re:      re,
+		lb:       lb,
+		c:        &Context{},
+		nbefore:  nbefore,
+		nafter:   nafter,
+		ms:       ms,
+		lineBase: 1,
+		logger:   discardLogger{},
 	}
 	switch {
 	case nbefore == 0 && nafter == 0:
@@ -139,55 +782,166 @@ func (fr *FileReader) Reset() {
 	fr.c = &Context{}
 	fr.cs = fr.cs[:0]
 	fr.loc = fr.loc[:0]
+	fr.patterns = nil
+	fr.count = 0
+	fr.truncated = false
+	fr.lastHeader = nil
+	fr.wholeText = false
+	fr.mlSpans = nil
+}
+
+// match sets location of first matched pattern and indexes of all matched
+// patterns for current text.
+func (fr *FileReader) match() {
+	fr.loc = nil
+	fr.patterns = nil
+	for i, m := range fr.ms {
+		loc := findIndex(m, fr.text)
+		if loc == nil {
+			continue
+		}
+		if fr.loc == nil {
+			fr.loc = loc
+		}
+		fr.patterns = append(fr.patterns, i)
+	}
+	if fr.invert {
+		if fr.loc != nil {
+			fr.loc = nil
+			fr.patterns = nil
+			return
+		}
+		fr.loc = []int{0, 0}
+	}
+}
+
+// matchMultiline is match for multiline mode, locations of matches in
+// whole text are clipped by current line.
+func (fr *FileReader) matchMultiline() {
+	fr.loc = nil
+	fr.patterns = nil
+	fr.lineSpans = fr.lineSpans[:0]
+	start, end := fr.offset, fr.offset+len(fr.text)
+	for i, m := range fr.ms {
+		if _, ok := m.(IndexMatcher); !ok {
+			if m.Match(fr.text) {
+				if fr.loc == nil {
+					fr.loc = []int{0, 0}
+				}
+				fr.patterns = append(fr.patterns, i)
+			}
+			continue
+		}
+		// drop matches that ended before current line.
+		spans := fr.mlSpans[i]
+		for len(spans) != 0 && (spans[0][1] < start || spans[0][1] == start && spans[0][0] != start) {
+			spans = spans[1:]
+		}
+		fr.mlSpans[i] = spans
+		matched := false
+		for _, span := range spans {
+			if span[0] >= fr.next {
+				break
+			}
+			loc := []int{span[0] - start, span[1] - start}
+			if span[0] < start {
+				loc[0] = 0
+			}
+			if span[0] > end {
+				loc[0] = end - start
+			}
+			if span[1] > end {
+				loc[1] = end - start
+			}
+			if fr.loc == nil {
+				fr.loc = loc
+			}
+			fr.lineSpans = append(fr.lineSpans, loc)
+			matched = true
+		}
+		if matched {
+			fr.patterns = append(fr.patterns, i)
+		}
+	}
+	if fr.invert {
+		if fr.loc != nil {
+			fr.loc = nil
+			fr.patterns = nil
+			return
+		}
+		fr.loc = []int{0, 0}
+	}
+}
+
+// setMatch sets result of match to c.
+func (fr *FileReader) setMatch(c *Context) {
+	c.loc = fr.loc
+	c.patterns = fr.patterns
+	c.trimIndent = fr.trimIndent
+	if fr.annotation != nil && !fr.invert {
+		c.annotation = parseAnnotation(fr.annotation, fr.text)
+	}
+	c.enclosing = fr.lastHeader
+	if fr.hl != nil && !fr.invert {
+		c.hl = fr.hl
+		if fr.wholeText {
+			c.spans = mergeSpans(append([][]int{}, fr.lineSpans...))
+		} else {
+			c.spans = matchSpans(fr.ms, fr.text)
+		}
+	}
 }
 
 // TODO: fix
 func (fr *FileReader) appendLine() {
 	if len(fr.loc) == 2 {
-		fr.cs = append(fr.cs, &Context{
+		c := &Context{
 			index: 0,
-			loc:   fr.loc,
-			lines: []*Line{{fr.i, fr.text}},
-		})
+			lines: []*Line{{fr.num(), fr.text}},
+		}
+		fr.setMatch(c)
+		fr.cs = append(fr.cs, c)
 	}
 }
 func (fr *FileReader) appendContext() {
 	if len(fr.c.loc) == 2 {
 		if len(fr.loc) == 2 {
-			fr.c.lines = append(fr.c.lines, fr.lb.popAll()...)
+			fr.c.lines = fr.lb.appendTo(fr.c.lines)
 			fr.cs = append(fr.cs, fr.c)
 			fr.c = &Context{
 				index: 0,
-				lines: []*Line{{fr.i, fr.text}},
-				loc:   fr.loc,
+				lines: []*Line{{fr.num(), fr.text}},
 			}
+			fr.setMatch(fr.c)
 			return
 		}
 		if fr.lb.len() == fr.nafter {
-			fr.c.lines = append(fr.c.lines, fr.lb.popAll()...)
+			fr.c.lines = fr.lb.appendTo(fr.c.lines)
 			fr.cs = append(fr.cs, fr.c)
 			fr.c = &Context{}
+			// current line is a before line of next match
+			fr.lb.push(fr.lb.newLine(fr.num(), fr.text))
 			return
 		}
-		fr.lb.push(&Line{fr.i, fr.text})
+		fr.lb.push(fr.lb.newLine(fr.num(), fr.text))
 		return
 	}
 	if len(fr.loc) == 2 {
-		fr.c.lines = append(fr.lb.popAll(), &Line{fr.i, fr.text})
+		fr.c.lines = append(fr.lb.appendTo(make([]*Line, 0, fr.lb.len()+1+fr.nafter)), &Line{fr.num(), fr.text})
 		fr.c.index = len(fr.c.lines) - 1
-		fr.c.loc = fr.loc
+		fr.setMatch(fr.c)
 		return
 	}
 	if fr.lb.len() == fr.nbefore {
 		fr.lb.del()
 	}
-	fr.lb.push(&Line{fr.i, fr.text})
+	fr.lb.push(fr.lb.newLine(fr.num(), fr.text))
 }
 func (fr *FileReader) appendBeforeLines() {
 	if len(fr.loc) == 2 {
-		fr.c.lines = append(fr.lb.popAll(), &Line{fr.i, fr.text})
+		fr.c.lines = append(fr.lb.appendTo(make([]*Line, 0, fr.lb.len()+1+fr.nafter)), &Line{fr.num(), fr.text})
 		fr.c.index = len(fr.c.lines) - 1
-		fr.c.loc = fr.loc
+		fr.setMatch(fr.c)
 		fr.cs = append(fr.cs, fr.c)
 		fr.c = &Context{}
 		return
@@ -195,22 +949,22 @@ func (fr *FileReader) appendBeforeLines() {
 	if fr.lb.len() == fr.nbefore {
 		fr.lb.del()
 	}
-	fr.lb.push(&Line{fr.i, fr.text})
+	fr.lb.push(fr.lb.newLine(fr.num(), fr.text))
 }
 func (fr *FileReader) appendAfterLines() {
 	if len(fr.loc) == 2 {
 		if len(fr.c.loc) == 2 {
-			fr.c.lines = append(fr.c.lines, fr.lb.popAll()...)
+			fr.c.lines = fr.lb.appendTo(fr.c.lines)
 			fr.cs = append(fr.cs, fr.c)
 			fr.c = &Context{}
 		}
 		fr.c.index = 0
-		fr.c.lines = []*Line{{fr.i, fr.text}}
-		fr.c.loc = fr.loc
+		fr.c.lines = []*Line{{fr.num(), fr.text}}
+		fr.setMatch(fr.c)
 		return
 	} else if len(fr.c.loc) == 2 {
 		if fr.lb.len() == fr.nafter {
-			fr.c.lines = append(fr.c.lines, fr.lb.popAll()...)
+			fr.c.lines = fr.lb.appendTo(fr.c.lines)
 			fr.cs = append(fr.cs, fr.c)
 			fr.c = &Context{}
 			return
@@ -218,7 +972,63 @@ func (fr *FileReader) appendAfterLines() {
 		if fr.lb.len() == fr.nafter {
 			fr.lb.del()
 		}
-		fr.lb.push(&Line{fr.i, fr.text})
+		fr.lb.push(fr.lb.newLine(fr.num(), fr.text))
+	}
+}
+
+// num returns number of current line for results.
+func (fr *FileReader) num() uint {
+	return fr.i - 1 + fr.lineBase
+}
+
+// readLine matches current line and appends it to contexts, it reports
+// whether reading should be stopped.
+func (fr *FileReader) readLine() bool {
+	if fr.header != nil {
+		defer func() {
+			if fr.header.MatchString(fr.text) {
+				fr.lastHeader = &Line{fr.num(), fr.text}
+			}
+		}()
+	}
+	if fr.wholeText {
+		fr.matchMultiline()
+	} else {
+		fr.match()
+	}
+	if fr.namesOnly {
+		if len(fr.loc) != 2 {
+			return false
+		}
+		fr.count++
+		fr.notifyMatch()
+		fr.appendLine()
+		return true
+	}
+	if len(fr.loc) == 2 && fr.maxMatches > 0 && fr.count >= fr.maxMatches {
+		fr.truncated = true
+		if !fr.countOnly {
+			// as not matched for after lines
+			fr.loc = nil
+			fr.patterns = nil
+		}
+	}
+	if len(fr.loc) == 2 {
+		fr.count++
+		fr.last = fr.i
+		fr.notifyMatch()
+	}
+	if fr.countOnly {
+		return false
+	}
+	fr.appendFunc()
+	return fr.truncated && fr.i >= fr.last+uint(fr.nafter)
+}
+
+// notifyMatch calls onMatch for current line if set.
+func (fr *FileReader) notifyMatch() {
+	if fr.onMatch != nil {
+		fr.onMatch(fr.path, fr.num(), fr.text)
 	}
 }
 
@@ -228,37 +1038,184 @@ func (fr *FileReader) ReadFile(path string) (*File, error) {
 		return nil, err
 	}
 	defer f.Close()
+	setReadDeadline(f, fr.deadline)
+	return fr.Read(path, f)
+}
+
+// Read reads contents from r as a file of path.
+func (fr *FileReader) Read(path string, r io.Reader) (*File, error) {
 	defer fr.Reset()
+	fr.path = path
 
-	sc := bufio.NewScanner(f)
-	for fr.i = uint(1); sc.Scan(); fr.i++ {
-		if fr.i == 0 {
+	var err error
+	if !fr.deadline.IsZero() {
+		r = &deadlineReader{r: r, deadline: fr.deadline}
+	}
+	if fr.decode != nil {
+		r = fr.decode(r)
+	} else {
+		r = newTextReader(r)
+	}
+	br := bufio.NewReader(r)
+	if fr.binary == BinaryNulByte {
+		probe, _ := br.Peek(binaryProbeSize)
+		if bytes.IndexByte(probe, 0) != -1 {
+			return nil, &ExpectedError{path: path, err: ErrUnavailableText}
+		}
+	}
+	split := bufio.ScanLines
+	if fr.splitCR {
+		split = scanLines
+	}
+	var sc *bufio.Scanner
+	var b []byte
+	if fr.multiline {
+		lr := io.Reader(br)
+		if fr.maxWholeText > 0 {
+			lr = io.LimitReader(br, fr.maxWholeText+1)
+		}
+		b, err = ioutil.ReadAll(lr)
+		if err == ErrTimeout {
+			return nil, &ExpectedError{path: path, err: err}
+		} else if err != nil {
+			return nil, err
+		}
+		fr.wholeText = fr.maxWholeText <= 0 || int64(len(b)) <= fr.maxWholeText
+		if !fr.wholeText {
+			fr.logger.Debugf("multiline fallback %s: larger than %d bytes", path, fr.maxWholeText)
+		}
+	}
+	if fr.wholeText {
+		text := string(b)
+		fr.mlSpans = make([][][]int, len(fr.ms))
+		for i, m := range fr.ms {
+			if im, ok := m.(IndexMatcher); ok {
+				fr.mlSpans[i] = im.FindAllIndex(text, -1)
+			}
+		}
+		// record offsets of lines.
+		pos := 0
+		sc = bufio.NewScanner(bytes.NewReader(b))
+		sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			advance, token, err := split(data, atEOF)
+			if token != nil {
+				fr.offset, fr.next = pos, pos+advance
+			}
+			pos += advance
+			return advance, token, err
+		})
+	} else {
+		// read lines after the head read for multiline mode.
+		sc = bufio.NewScanner(io.MultiReader(bytes.NewReader(b), br))
+		sc.Split(split)
+	}
+	if fr.maxLineSize > 0 {
+		init := fr.maxLineSize
+		if init > bufio.MaxScanTokenSize {
+			init = bufio.MaxScanTokenSize
+		}
+		sc.Buffer(make([]byte, 0, init), fr.maxLineSize)
+	}
+	if fr.tailLines > 0 {
+		if fr.tail == nil || fr.tail.capa != fr.tailLines {
+			fr.tail = newTailBuffer(fr.tailLines)
+		}
+		fr.tail.reset()
+	}
+	// lines are limited by headLines or tailLines
+	limited := false
+	for fr.i = fr.lineOffset; sc.Scan(); {
+		// the line can not be numbered
+		if fr.i == maxLineNum {
 			return nil, &ExpectedError{path: path, err: ErrTooManyLines}
 		}
+		fr.i++
+		if fr.expired() {
+			return nil, &ExpectedError{path: path, err: ErrTimeout}
+		}
+		if fr.headLines > 0 && fr.i > uint(fr.headLines) {
+			limited = true
+			break
+		}
 		fr.text = sc.Text()
-		if !utf8.ValidString(fr.text) {
+		if (fr.binary == "" || fr.binary == BinaryUTF8) && !utf8.ValidString(fr.text) {
 			return nil, &ExpectedError{path: path, err: ErrUnavailableText}
 		}
-		fr.loc = fr.re.FindStringIndex(fr.text)
-		fr.appendFunc()
+		if fr.tailLines > 0 {
+			fr.tail.push(tailLine{num: fr.i, str: fr.text, offset: fr.offset, next: fr.next})
+			continue
+		}
+		if fr.readLine() {
+			break
+		}
 	}
 	if err = sc.Err(); err != nil {
-		if err == bufio.ErrTooLong {
+		if err == bufio.ErrTooLong || err == ErrTimeout {
 			return nil, &ExpectedError{path: path, err: err}
 		}
 		return nil, err
 	}
+	// matching the last line may exceed, e.g. backtracking of regexp2
+	if fr.expired() {
+		return nil, &ExpectedError{path: path, err: ErrTimeout}
+	}
+	if fr.tailLines > 0 {
+		limited = limited || fr.tail.dropped
+		for _, l := range fr.tail.lines() {
+			fr.i, fr.text, fr.offset, fr.next = l.num, l.str, l.offset, l.next
+			if fr.readLine() {
+				break
+			}
+		}
+	}
 
 	// append last one
 	if len(fr.c.loc) == 2 {
-		fr.c.lines = append(fr.c.lines, fr.lb.popAll()...)
+		fr.c.lines = fr.lb.appendTo(fr.c.lines)
 		fr.cs = append(fr.cs, fr.c)
 	}
 
 	file := &File{
-		Path:     path,
-		Contexts: make([]*Context, len(fr.cs)),
+		Path:      path,
+		Contexts:  make([]*Context, len(fr.cs)),
+		count:     fr.count,
+		truncated: fr.truncated || limited,
 	}
 	copy(file.Contexts, fr.cs)
+	if fr.merge {
+		file.Contexts = mergeContexts(file.Contexts)
+	}
 	return file, nil
 }
+
+// expired reports whether the deadline is passed.
+func (fr *FileReader) expired() bool {
+	return !fr.deadline.IsZero() && time.Now().After(fr.deadline)
+}
+
+// deadlineReader fails with ErrTimeout after deadline, e.g. a long line
+// that is read by several reads.
+type deadlineReader struct {
+	r        io.Reader
+	deadline time.Time
+}
+
+func (dr *deadlineReader) Read(p []byte) (int, error) {
+	if time.Now().After(dr.deadline) {
+		return 0, ErrTimeout
+	}
+	n, err := dr.r.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = ErrTimeout
+	}
+	return n, err
+}
+
+// setReadDeadline limits blocked reads of r by deadline if r supports it,
+// e.g. named pipes. zero deadline is no limit.
+func setReadDeadline(r io.Reader, deadline time.Time) {
+	if d, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok && !deadline.IsZero() {
+		// regular files are not supported, they are not blocked
+		d.SetReadDeadline(deadline)
+	}
+}
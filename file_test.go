@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 var readFileTests = []struct {
@@ -18,7 +26,40 @@ var readFileTests = []struct {
 	{
 		str: "hello world",
 		pat: "world",
-		exp: "1:hello world",
+		exp: "1:hello world\n",
+	},
+	{
+		str:     "1\n2\n3 TODO\n4\n5\n6\n7 TODO\n8\n",
+		pat:     "TODO",
+		nbefore: 2,
+		exp:     "1-1\n2-2\n3:3 TODO\n5-5\n6-6\n7:7 TODO\n",
+	},
+	{
+		str:    "1\n2\n3 TODO\n4\n5\n6\n7\n8 TODO\n9\n",
+		pat:    "TODO",
+		nafter: 3,
+		exp:    "3:3 TODO\n4-4\n5-5\n6-6\n8:8 TODO\n9-9\n",
+	},
+	{
+		str:     "1 TODO\n2\n3\n4 TODO\n5\n",
+		pat:     "TODO",
+		nbefore: 1,
+		nafter:  1,
+		exp:     "1:1 TODO\n2-2\n3-3\n4:4 TODO\n5-5\n",
+	},
+	{
+		str:     "1\n2 TODO\n3\n4\n5\n6 TODO\n7\n8\n",
+		pat:     "TODO",
+		nbefore: 2,
+		nafter:  2,
+		exp:     "1-1\n2:2 TODO\n3-3\n4-4\n5-5\n6:6 TODO\n7-7\n8-8\n",
+	},
+	{
+		str:     "1\n2\n3\n4 TODO\n5\n6\n7\n8\n9\n10 TODO\n",
+		pat:     "TODO",
+		nbefore: 2,
+		nafter:  2,
+		exp:     "2-2\n3-3\n4:4 TODO\n5-5\n6-6\n8-8\n9-9\n10:10 TODO\n",
 	},
 }
 
@@ -51,7 +92,6 @@ func TestReadFile(t *testing.T) {
 		return str
 	}
 
-	// TODO: compare
 	for _, test := range readFileTests {
 		_, err = tmpf.WriteString(test.str)
 		if err != nil {
@@ -63,7 +103,772 @@ func TestReadFile(t *testing.T) {
 			t.Fatal(err)
 		}
 		fr.Reset()
-		t.Logf("out:%+v\nPath:%s\nContexts:%s\n", out, out.Path, scontexts(out.Contexts))
+		if s := scontexts(out.Contexts); s != test.exp {
+			t.Errorf("before=%d after=%d:\nout=%q\nexp=%q", test.nbefore, test.nafter, s, test.exp)
+		}
 		reset()
 	}
 }
+
+// readString reads str as a file by fr.
+func readString(t *testing.T, fr *FileReader, str string) *File {
+	t.Helper()
+	tmpf, err := ioutil.TempFile("", "test_readfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpf.Name())
+	defer tmpf.Close()
+	if _, err = tmpf.WriteString(str); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fr.ReadFile(tmpf.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestReadEOFFlush(t *testing.T) {
+	tests := []struct {
+		str             string
+		nbefore, nafter int
+		merge           bool
+		exp             string
+	}{
+		// a matched line ends after lines of the previous context
+		{str: "a\nb\nTODO 1\nTODO 2\n", nbefore: 2, nafter: 2,
+			exp: "1-a\n2-b\n3:TODO 1\n4:TODO 2\n"},
+		{str: "a\nb\nTODO 1\nTODO 2", nbefore: 1, nafter: 1,
+			exp: "2-b\n3:TODO 1\n4:TODO 2\n"},
+		{str: "a\nb\nTODO 1\nTODO 2\nc\n", nbefore: 1, nafter: 2,
+			exp: "2-b\n3:TODO 1\n4:TODO 2\n5-c\n"},
+		{str: "a\nb\nTODO 1\nTODO 2\n", nbefore: 2, nafter: 2, merge: true,
+			exp: "1-a\n2-b\n3:TODO 1\n4:TODO 2\n"},
+		{str: "a\nTODO 1\nb\nTODO 2\nc\n", nafter: 3,
+			exp: "2:TODO 1\n3-b\n4:TODO 2\n5-c\n"},
+		{str: "a\nTODO 1\nb\nTODO 2\nc\n", nafter: 3, merge: true,
+			exp: "2:TODO 1\n3-b\n4:TODO 2\n5-c\n"},
+		{str: "TODO 1\nTODO 2\nTODO 3\n", nbefore: 3, nafter: 3,
+			exp: "1:TODO 1\n2:TODO 2\n3:TODO 3\n"},
+		{str: "TODO 1\nTODO 2\nTODO 3\n", nbefore: 3, nafter: 3, merge: true,
+			exp: "1:TODO 1\n2:TODO 2\n3:TODO 3\n"},
+	}
+	for _, test := range tests {
+		fr := NewFileReader(regexp.MustCompile("TODO"), test.nbefore, test.nafter)
+		fr.merge = test.merge
+		f, err := fr.Read("eof", strings.NewReader(test.str))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("str=%q before=%d after=%d merge=%v:\nout=%q\nexp=%q",
+				test.str, test.nbefore, test.nafter, test.merge, out, test.exp)
+		}
+	}
+}
+
+func TestReadNoLeakAcrossFiles(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 2, 2)
+	tests := []struct {
+		name, str, exp string
+		err            error
+	}{
+		// after lines are pending at EOF
+		{name: "a", str: "a1\na2\nTODO: a\n", exp: "1-a1\n2-a2\n3:TODO: a\n"},
+		{name: "b", str: "b1\nTODO: b\nb3\n", exp: "1-b1\n2:TODO: b\n3-b3\n"},
+		// before lines are buffered at an error
+		{name: "c", str: "c1\nc2\n\xff\n", err: ErrUnavailableText},
+		{name: "d", str: "TODO: d\n", exp: "1:TODO: d\n"},
+		{name: "e", str: "e1\ne2\ne3\n", exp: ""},
+		{name: "f", str: "TODO: f\n", exp: "1:TODO: f\n"},
+	}
+	for _, test := range tests {
+		f, err := fr.Read(test.name, strings.NewReader(test.str))
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: err=%v, exp %v", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("%s: out=%q, exp=%q", test.name, out, test.exp)
+		}
+	}
+}
+
+// endlessLine is a line that never ends, each read is slow.
+type endlessLine time.Duration
+
+func (d endlessLine) Read(p []byte) (int, error) {
+	time.Sleep(time.Duration(d))
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestReadDeadline(t *testing.T) {
+	tests := []struct {
+		multiline bool
+	}{
+		{multiline: false},
+		{multiline: true},
+	}
+	for _, test := range tests {
+		fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+		fr.multiline = test.multiline
+		fr.deadline = time.Now().Add(50 * time.Millisecond)
+		start := time.Now()
+		_, err := fr.Read("endless", endlessLine(time.Millisecond))
+		if e, ok := err.(*ExpectedError); !ok || e.err != ErrTimeout {
+			t.Errorf("multiline=%v: err=%v, exp expected error of %v", test.multiline, err, ErrTimeout)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("multiline=%v: deadline is not applied: %v", test.multiline, d)
+		}
+	}
+}
+
+func TestTooManyLines(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 0)
+	fr.lineOffset = maxLineNum - 2
+	f, err := fr.Read("max", strings.NewReader("a\nTODO\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := f.Contexts[0].Line(); l.Num != maxLineNum {
+		t.Errorf("num=%d, exp %d", l.Num, maxLineNum)
+	}
+
+	_, err = fr.Read("overflow", strings.NewReader("a\nb\nTODO\n"))
+	if !errors.Is(err, ErrTooManyLines) || !isExpected(err) {
+		t.Errorf("err=%v, exp expected error of %v", err, ErrTooManyLines)
+	}
+}
+
+func TestLinesBuffer(t *testing.T) {
+	if _, err := newLinesBuffer(0); err == nil {
+		t.Error("expected error for capacity 0")
+	}
+	lb, err := newLinesBuffer(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb.del() {
+		t.Error("del of empty buffer reported true")
+	}
+	for i := uint(1); i <= 5; i++ {
+		lb.push(lb.newLine(i, fmt.Sprint(i)))
+	}
+	if lb.len() != 3 {
+		t.Errorf("len=%d, exp 3", lb.len())
+	}
+	if !lb.del() {
+		t.Error("del reported false")
+	}
+	lb.push(lb.newLine(6, "6"))
+	var out []uint
+	for _, l := range lb.appendTo(nil) {
+		out = append(out, l.Num)
+	}
+	if exp := []uint{4, 5, 6}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%v, exp=%v", out, exp)
+	}
+	if lb.len() != 0 || lb.del() {
+		t.Errorf("not cleared: len=%d", lb.len())
+	}
+}
+
+func TestContextRange(t *testing.T) {
+	str := "TODO\nb\nc\nTODO\ne\nf\ng\nh\nTODO\n"
+	tests := []struct {
+		nbefore, nafter int
+		exp             [][2]uint
+	}{
+		{exp: [][2]uint{{1, 1}, {4, 4}, {9, 9}}},
+		{nbefore: 2, nafter: 1, exp: [][2]uint{{1, 2}, {3, 5}, {7, 9}}},
+		{nafter: 3, exp: [][2]uint{{1, 3}, {4, 7}, {9, 9}}},
+	}
+	for _, test := range tests {
+		f := readString(t, NewFileReader(regexp.MustCompile("TODO"), test.nbefore, test.nafter), str)
+		var out [][2]uint
+		for _, c := range f.Contexts {
+			start, end := c.Range()
+			out = append(out, [2]uint{start, end})
+		}
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("before=%d after=%d: out=%v, exp=%v", test.nbefore, test.nafter, out, test.exp)
+		}
+	}
+}
+
+func TestMatchedPattern(t *testing.T) {
+	res := []*regexp.Regexp{
+		regexp.MustCompile("TODO"),
+		regexp.MustCompile("FIXME"),
+	}
+	str := "FIXME: a\nnone\nTODO: b\nnone\nnone\nFIXME TODO: c\n"
+	for _, n := range []int{0, 1, 2} {
+		f := readString(t, NewMultiFileReader(res, n, n), str)
+		var out []int
+		for _, c := range f.Contexts {
+			out = append(out, c.MatchedPattern())
+		}
+		if exp := []int{1, 0, 0}; !reflect.DeepEqual(out, exp) {
+			t.Errorf("context=%d: out=%v, exp=%v", n, out, exp)
+		}
+	}
+
+	f := readString(t, NewFileReader(regexp.MustCompile("TODO"), 1, 1), str)
+	for _, c := range f.Contexts {
+		if out := c.MatchedPattern(); out != 0 {
+			t.Errorf("single pattern: line %d: out=%d", c.Line().Num, out)
+		}
+	}
+
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	fr.invert = true
+	f = readString(t, fr, str)
+	if out := f.Contexts[0].MatchedPattern(); out != -1 {
+		t.Errorf("invert: out=%d, exp=-1", out)
+	}
+}
+
+func TestMultiFileReader(t *testing.T) {
+	res := []*regexp.Regexp{
+		regexp.MustCompile("TODO"),
+		regexp.MustCompile("FIXME"),
+		regexp.MustCompile("XXX"),
+	}
+	str := "TODO: a\nnone\nFIXME: b\nTODO FIXME: c\n"
+	exp := [][]int{{0}, {1}, {0, 1}}
+
+	for _, n := range []int{0, 1} {
+		f := readString(t, NewMultiFileReader(res, n, n), str)
+		var out [][]int
+		for _, c := range f.Contexts {
+			out = append(out, c.Patterns())
+		}
+		if !reflect.DeepEqual(out, exp) {
+			t.Errorf("context=%d: out=%v, exp=%v", n, out, exp)
+		}
+	}
+
+	f := readString(t, NewMultiFileReader(res, 0, 0), str)
+	tags := []string{"TODO", "FIXME", "XXX"}
+	if out, exp := f.Contexts[2].TaggedString(tags), "4:[TODO,FIXME]:TODO FIXME: c\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestInvertMatch(t *testing.T) {
+	str := "a\nTODO\nb\nc\nTODO\n"
+	tests := []struct {
+		nbefore, nafter int
+		exp             string
+	}{
+		{0, 0, "1:a\n3:b\n4:c\n"},
+		{1, 0, "1:a\n2-TODO\n3:b\n4:c\n"},
+		{0, 1, "1:a\n2-TODO\n3:b\n4:c\n5-TODO\n"},
+	}
+	for _, test := range tests {
+		fr := NewFileReader(regexp.MustCompile("TODO"), test.nbefore, test.nafter)
+		fr.invert = true
+		f := readString(t, fr, str)
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("before=%d after=%d:\nout=%q\nexp=%q", test.nbefore, test.nafter, out, test.exp)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	str := "before TODO\nあいTODO: x TODO\nafter TODO\n"
+	fr := NewFileReader(regexp.MustCompile("x TODO|あいTODO"), 1, 1)
+	fr.hl = &highlight{start: ">>>", end: "<<<"}
+	f := readString(t, fr, str)
+	if len(f.Contexts) != 1 {
+		t.Fatalf("contexts=%d, exp 1", len(f.Contexts))
+	}
+	exp := "1-before TODO\n2:>>>あいTODO<<<: >>>x TODO<<<\n3-after TODO\n"
+	if out := f.Contexts[0].String(); out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+	if out := f.Contexts[0].lines[1].Str; out != "あいTODO: x TODO" {
+		t.Errorf("stored line is changed: %q", out)
+	}
+}
+
+func TestHighlightAll(t *testing.T) {
+	tests := []struct {
+		pat, str, exp string
+	}{
+		{"TODO", "TOD\nTODO x TODO y TODO\nODO\n", "1-TOD\n2:>TODO< x >TODO< y >TODO<\n3-ODO\n"},
+		{"a*", "baaab a\n", "1:b>aaa<b >a<\n"},
+		{"a*", "xyz\n", "1:xyz\n"},
+		{"TODOTODO|TODO", "TODOTODOTODO\n", "1:>TODOTODOTODO<\n"},
+	}
+	for _, test := range tests {
+		fr := NewFileReader(regexp.MustCompile(test.pat), 1, 1)
+		fr.hl = &highlight{start: ">", end: "<"}
+		f := readString(t, fr, test.str)
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("pat=%q str=%q:\nout=%q\nexp=%q", test.pat, test.str, out, test.exp)
+		}
+	}
+}
+
+func TestTrimIndent(t *testing.T) {
+	str := "func f() {\n\t\t// TODO: x\n  \treturn\n}\n"
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	fr.trimIndent = true
+	fr.hl = &highlight{start: ">", end: "<"}
+	f := readString(t, fr, str)
+	if len(f.Contexts) != 1 {
+		t.Fatalf("contexts=%d, exp 1", len(f.Contexts))
+	}
+	exp := "1-func f() {\n2:// >TODO<: x\n3-return\n"
+	if out := f.Contexts[0].String(); out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+	if l := f.Contexts[0].Line(); l.Num != 2 || l.Str != "\t\t// TODO: x" {
+		t.Errorf("stored line is changed: %+v", l)
+	}
+	buf := new(bytes.Buffer)
+	if err := FprintFilesJSON(buf, f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"str":"\t\t// TODO: x"`) {
+		t.Errorf("JSON is trimmed: %s", buf)
+	}
+
+	// highlight of white spaces in the indent
+	fr = NewFileReader(regexp.MustCompile(`\s+TODO`), 0, 0)
+	fr.trimIndent = true
+	fr.hl = &highlight{start: ">", end: "<"}
+	f = readString(t, fr, "  TODO\n")
+	if out, exp := f.Contexts[0].String(), "1:>TODO<\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestSortFiles(t *testing.T) {
+	paths := []string{"b", "a-b/c", "a/c", "a/b/c", "a", "a.txt", "a/b"}
+	exp := []string{"a", "a/b", "a/b/c", "a/c", "a-b/c", "a.txt", "b"}
+	var fs []*File
+	for _, p := range paths {
+		fs = append(fs, &File{Path: filepath.FromSlash(p)})
+	}
+	SortFiles(fs)
+	var out []string
+	for _, f := range fs {
+		out = append(out, filepath.ToSlash(f.Path))
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestRead(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 1)
+	f, err := fr.Read("reader", strings.NewReader("TODO: a\nb\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path != "reader" || len(f.Contexts) != 1 {
+		t.Fatalf("out=%+v", f)
+	}
+	if out, exp := f.Contexts[0].String(), "1:TODO: a\n2-b\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+
+	if _, err = fr.Read("invalid", strings.NewReader("TODO\xff\n")); err == nil {
+		t.Error("expected error for invalid text")
+	}
+}
+
+func TestFprintVerbose(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	fr.merge = true
+	f, err := fr.Read("dir/a.go", strings.NewReader("a\nTODO: b\nc\nTODO: d\ne\nf\ng\nTODO: h\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f.FprintVerbose(&buf); err != nil {
+		t.Fatal(err)
+	}
+	exp := "dir/a.go:1-a\n" +
+		"dir/a.go:2:TODO: b\n" +
+		"dir/a.go:3-c\n" +
+		"dir/a.go:4:TODO: d\n" +
+		"dir/a.go:5-e\n" +
+		"dir/a.go:7-g\n" +
+		"dir/a.go:8:TODO: h\n"
+	if out := buf.String(); out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+}
+
+func TestBinaryDetection(t *testing.T) {
+	files := map[string]string{
+		"nul":     "TODO\x00\n",
+		"utf8bin": "TODO\n" + strings.Repeat("\x01\x02", 10) + "\n",
+		"latin1":  "TODO caf\xe9\n",
+		"text":    "TODO\n",
+	}
+	tests := []struct {
+		mode string
+		exp  map[string]bool // readable
+	}{
+		{mode: "", exp: map[string]bool{"nul": true, "utf8bin": true, "text": true}},
+		{mode: BinaryUTF8, exp: map[string]bool{"nul": true, "utf8bin": true, "text": true}},
+		{mode: BinaryNulByte, exp: map[string]bool{"utf8bin": true, "latin1": true, "text": true}},
+		{mode: BinaryOff, exp: map[string]bool{"nul": true, "utf8bin": true, "latin1": true, "text": true}},
+	}
+	for _, test := range tests {
+		for name, str := range files {
+			fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+			fr.binary = test.mode
+			_, err := fr.Read(name, strings.NewReader(str))
+			if out := err == nil; out != test.exp[name] {
+				t.Errorf("mode=%q %s: readable=%v, exp %v: %v", test.mode, name, out, test.exp[name], err)
+			}
+			if err != nil {
+				if e, ok := err.(*ExpectedError); !ok || e.err != ErrUnavailableText {
+					t.Errorf("mode=%q %s: unexpected error %v", test.mode, name, err)
+				}
+			}
+		}
+	}
+}
+
+func TestMaxLineSize(t *testing.T) {
+	long := strings.Repeat("x", 2<<20) + " TODO\n"
+	str := "a\n" + long + "b\n"
+
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	_, err := fr.Read("default", strings.NewReader(str))
+	if e, ok := err.(*ExpectedError); !ok || e.err != bufio.ErrTooLong {
+		t.Errorf("expected bufio.ErrTooLong: %v", err)
+	}
+
+	fr.maxLineSize = 4 << 20
+	f, err := fr.Read("raised", strings.NewReader(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Contexts) != 1 || f.Contexts[0].lines[0].Num != 2 {
+		t.Errorf("long line is not matched: %d contexts", len(f.Contexts))
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	tests := []struct {
+		name    string
+		splitCR bool
+		exp     string
+	}{
+		{name: "crlf.txt", splitCR: false, exp: "1-first\n2:TODO: crlf$\n3-last\n"},
+		{name: "crlf.txt", splitCR: true, exp: "1-first\n2:TODO: crlf$\n3-last\n"},
+		{name: "cr.txt", splitCR: true, exp: "1-first\n2:TODO: cr$\n3-last\n"},
+	}
+	for _, test := range tests {
+		// "$" must be matched to end of the line
+		fr := NewFileReader(regexp.MustCompile(`TODO: \w+\$$`), 1, 1)
+		fr.splitCR = test.splitCR
+		f, err := fr.ReadFile(filepath.Join("testdata", "lineending", test.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("%s splitCR=%v: out=%q, exp=%q", test.name, test.splitCR, out, test.exp)
+		}
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	in := "a\r\nb\rc\nd\r\r\ne\r"
+	exp := []string{"a", "b", "c", "d", "", "e"}
+	// small buffer to split "\r\n" between reads
+	sc := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(in)))
+	sc.Split(scanLines)
+	var out []string
+	for sc.Scan() {
+		out = append(out, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	str := "a\nTODO: x\ny END\nb\nTODO END\nc\n"
+	tests := []struct {
+		pat             string
+		nbefore, nafter int
+		exp             string
+	}{
+		{pat: `TODO[\s\S]*?END`, exp: "2:[TODO: x]\n3:[y END]\n5:[TODO END]\n"},
+		{pat: `TODO[\s\S]*?END`, nbefore: 1, nafter: 1, exp: "1-a\n2:[TODO: x]\n3:[y END]\n4-b\n5:[TODO END]\n6-c\n"},
+		{pat: `x\ny`, exp: "2:TODO: [x]\n3:[y] END\n"},
+		{pat: `(?m)^b$`, exp: "4:[b]\n"},
+	}
+	for _, test := range tests {
+		fr := NewFileReader(regexp.MustCompile(test.pat), test.nbefore, test.nafter)
+		fr.multiline = true
+		fr.hl = &highlight{start: "[", end: "]"}
+		f, err := fr.Read("multiline", strings.NewReader(str))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("pat=%q before=%d after=%d:\nout=%q\nexp=%q", test.pat, test.nbefore, test.nafter, out, test.exp)
+		}
+	}
+}
+
+func TestMultilineMaxBytes(t *testing.T) {
+	str := "a\nb TODO\n" // 9 bytes
+	tests := []struct {
+		max      int64
+		exp      string
+		fallback bool
+	}{
+		{max: 0, exp: "1:a\n2:b TODO\n"},
+		{max: 9, exp: "1:a\n2:b TODO\n"},
+		{max: 8, exp: "2:b TODO\n", fallback: true},
+	}
+	for _, test := range tests {
+		fr := NewMatcherFileReader([]Matcher{
+			NewRegexpMatcher(regexp.MustCompile(`a\nb`)),
+			NewRegexpMatcher(regexp.MustCompile(`TODO`)),
+		}, 0, 0)
+		fr.multiline = true
+		fr.maxWholeText = test.max
+		l := &testLogger{}
+		fr.logger = l
+		f, err := fr.Read("multiline", strings.NewReader(str))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("max=%d: out=%q, exp=%q", test.max, out, test.exp)
+		}
+		if fallback := len(l.debugs) != 0; fallback != test.fallback {
+			t.Errorf("max=%d: fallback=%v, logs=%q", test.max, fallback, l.debugs)
+		}
+	}
+}
+
+func TestMaxMatchesPerFile(t *testing.T) {
+	str := "1 TODO\n2\n3 TODO\n4\n5 TODO\n6 TODO\n"
+	tests := []struct {
+		max       int
+		countOnly bool
+		count     int
+		truncated bool
+		exp       string
+	}{
+		{max: 2, count: 2, truncated: true, exp: "1:1 TODO\n2-2\n3:3 TODO\n4-4\n"},
+		{max: 3, count: 3, truncated: true, exp: "1:1 TODO\n2-2\n3:3 TODO\n4-4\n5:5 TODO\n6-6 TODO\n"},
+		{max: 4, count: 4, truncated: false, exp: "1:1 TODO\n2-2\n3:3 TODO\n4-4\n5:5 TODO\n6:6 TODO\n"},
+		{max: 2, countOnly: true, count: 4, truncated: true},
+	}
+	for _, test := range tests {
+		fr := NewFileReader(regexp.MustCompile("TODO"), 0, 1)
+		fr.maxMatches = test.max
+		fr.countOnly = test.countOnly
+		f := readString(t, fr, str)
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp || f.Count() != test.count || f.truncated != test.truncated {
+			t.Errorf("max=%d countOnly=%v: count=%d truncated=%v\nout=%q\nexp=%q",
+				test.max, test.countOnly, f.Count(), f.truncated, out, test.exp)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	fr.maxMatches = 1
+	f, err := fr.Read("a.txt", strings.NewReader("TODO: a\nTODO: b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Truncated() {
+		t.Error("Truncated()=false, exp true")
+	}
+	buf := new(bytes.Buffer)
+	if err := f.FprintVerbose(buf); err != nil {
+		t.Fatal(err)
+	}
+	if out, exp := buf.String(), "a.txt:1:TODO: a\na.txt:[truncated]\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+	buf.Reset()
+	if err := FprintFilesJSON(buf, f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"truncated":true`) {
+		t.Errorf("JSON has no truncated: %s", buf)
+	}
+
+	fr.maxMatches = 2
+	if f, err = fr.Read("a.txt", strings.NewReader("TODO: a\nTODO: b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if f.Truncated() {
+		t.Error("Truncated()=true, exp false")
+	}
+}
+
+func TestHeadTailLines(t *testing.T) {
+	str := "1 TODO\n2\n3 TODO\n4\n5 TODO\n6\n"
+	tests := []struct {
+		head, tail int
+		multiline  bool
+		truncated  bool
+		exp        string
+	}{
+		{head: 2, truncated: true, exp: "1:1 TODO\n2-2\n"},
+		{head: 6, exp: "1:1 TODO\n2-2\n3:3 TODO\n4-4\n5:5 TODO\n6-6\n"},
+		{tail: 2, truncated: true, exp: "5:5 TODO\n6-6\n"},
+		{tail: 4, truncated: true, exp: "3:3 TODO\n4-4\n5:5 TODO\n6-6\n"},
+		{tail: 10, exp: "1:1 TODO\n2-2\n3:3 TODO\n4-4\n5:5 TODO\n6-6\n"},
+		{head: 4, tail: 2, truncated: true, exp: "3:3 TODO\n4-4\n"},
+		{tail: 2, multiline: true, truncated: true, exp: "5:5 TODO\n6-6\n"},
+	}
+	for _, test := range tests {
+		fr := NewFileReader(regexp.MustCompile("TODO"), 0, 1)
+		fr.headLines = test.head
+		fr.tailLines = test.tail
+		fr.multiline = test.multiline
+		f := readString(t, fr, str)
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp || f.truncated != test.truncated {
+			t.Errorf("head=%d tail=%d: truncated=%v\nout=%q\nexp=%q",
+				test.head, test.tail, f.truncated, out, test.exp)
+		}
+	}
+}
+
+func TestEnclosing(t *testing.T) {
+	str := `package a
+
+func f() {
+	x := 1
+	// TODO: a
+	_ = x
+}
+
+func g() {
+	// TODO: b
+}
+
+// TODO: c
+`
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	fr.header = regexp.MustCompile("^func ")
+	f := readString(t, fr, str)
+	var out string
+	for _, c := range f.Contexts {
+		out += c.String()
+	}
+	exp := "3=func f() {\n5:\t// TODO: a\n" + "9=func g() {\n10:\t// TODO: b\n" + "9=func g() {\n13:// TODO: c\n"
+	if out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+
+	// in before lines
+	fr = NewFileReader(regexp.MustCompile("TODO"), 2, 0)
+	fr.header = regexp.MustCompile("^func ")
+	f = readString(t, fr, "func f() {\n\t// TODO: a\n}\n")
+	if out, exp := f.Contexts[0].String(), "1-func f() {\n2:\t// TODO: a\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+	if l := f.Contexts[0].Enclosing(); l == nil || l.Num != 1 {
+		t.Errorf("Enclosing()=%v", l)
+	}
+}
+
+func TestAnnotation(t *testing.T) {
+	str := "// TODO(alice): [P1] rewrite\n// TODO(bob): later\n// TODO: none\n"
+	exp := []Annotation{
+		{Author: "alice", Priority: "P1"},
+		{Author: "bob"},
+		{},
+	}
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	fr.annotation = regexp.MustCompile(`TODO\((?P<author>[^)]+)\):(?:\s*\[(?P<priority>P\d)\])?`)
+	f := readString(t, fr, str)
+	var out []Annotation
+	for _, c := range f.Contexts {
+		out = append(out, c.Annotation())
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%+v, exp=%+v", out, exp)
+	}
+}
+
+func BenchmarkReadContext(b *testing.B) {
+	str := strings.Repeat(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 20)+"// TODO: fix\n", 500)
+	fr := NewFileReader(regexp.MustCompile("TODO"), 3, 3)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(str)))
+	for i := 0; i < b.N; i++ {
+		if _, err := fr.Read("bench", strings.NewReader(str)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// many context shifts in a large window.
+func BenchmarkReadWideContext(b *testing.B) {
+	str := strings.Repeat(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 200)+"// TODO: fix\n", 50)
+	fr := NewFileReader(regexp.MustCompile("TODO"), 64, 64)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(str)))
+	for i := 0; i < b.N; i++ {
+		if _, err := fr.Read("bench", strings.NewReader(str)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+// SetFS sets file system to scan, paths are slash separated and unrooted
+// as fs.FS, e.g. ".". nil is the file system of OS.
+// symbolic links in fsys are not resolved for checking duplicates.
+func (w *Walker) SetFS(fsys fs.FS) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.fsys = fsys
+	return nil
+}
+
+// abs returns absolute path, or cleaned path if w.fsys is specified.
+func (w *Walker) abs(name string) (string, error) {
+	if w.fsys == nil {
+		return filepath.Abs(name)
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+func (w *Walker) join(dir, name string) string {
+	if w.fsys == nil {
+		return filepath.Join(dir, name)
+	}
+	return path.Join(dir, name)
+}
+
+func (w *Walker) open(name string) (fs.File, error) {
+	if w.fsys == nil {
+		if w.includeNonRegular {
+			// a named pipe is opened without waiting for a writer
+			return os.OpenFile(name, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		}
+		return os.Open(name)
+	}
+	return w.fsys.Open(name)
+}
+
+func (w *Walker) stat(name string) (os.FileInfo, error) {
+	if w.fsys == nil {
+		return os.Stat(name)
+	}
+	return fs.Stat(w.fsys, name)
+}
+
+// readDir returns sorted entries of dir.
+func (w *Walker) readDir(dir string) ([]os.FileInfo, error) {
+	if w.fsys == nil {
+		return ioutil.ReadDir(dir)
+	}
+	des, err := fs.ReadDir(w.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	fis := make([]os.FileInfo, 0, len(des))
+	for _, de := range des {
+		fi, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+// evalSymlinks returns name as is if w.fsys is specified.
+func (w *Walker) evalSymlinks(name string) (string, error) {
+	if w.fsys == nil {
+		return filepath.EvalSymlinks(name)
+	}
+	return name, nil
+}
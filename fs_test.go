@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// countFS counts files except directories opened from fsys.
+type countFS struct {
+	fsys fs.FS
+
+	mu      sync.Mutex
+	opened  int
+	nopen   int
+	maxOpen int
+}
+
+func (c *countFS) Open(name string) (fs.File, error) {
+	f, err := c.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		return f, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opened++
+	if c.nopen++; c.nopen > c.maxOpen {
+		c.maxOpen = c.nopen
+	}
+	return &countFile{File: f, c: c}, nil
+}
+
+// Stat is not counted as opening.
+func (c *countFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(c.fsys, name)
+}
+
+type countFile struct {
+	fs.File
+	c *countFS
+}
+
+func (f *countFile) Close() error {
+	f.c.mu.Lock()
+	f.c.nopen--
+	f.c.mu.Unlock()
+	return f.File.Close()
+}
+
+// slowFS sleeps d on opening files.
+type slowFS struct {
+	fs.FS
+	d time.Duration
+}
+
+func (s slowFS) Open(name string) (fs.File, error) {
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && !fi.IsDir() {
+		time.Sleep(s.d)
+	}
+	return f, nil
+}
+
+// denyFS denies opening names in deny.
+type denyFS struct {
+	fs.FS
+	deny map[string]bool
+}
+
+func (d denyFS) Open(name string) (fs.File, error) {
+	if d.deny[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return d.FS.Open(name)
+}
+
+func TestWalkerSetFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("TODO: a\n")},
+		"b.txt":          {Data: []byte("none\n")},
+		"dir/c.txt":      {Data: []byte("x\nTODO: c\n")},
+		"dir/d.log":      {Data: []byte("TODO: d\n")},
+		"dir/.gitignore": {Data: []byte("*.log\n")},
+		"dir/sub/e.txt":  {Data: []byte("TODO: e\n")},
+	}
+	tests := []struct {
+		paths    []string
+		maxDepth int
+		exp      []string
+	}{
+		{
+			paths:    []string{"."},
+			maxDepth: -1,
+			exp:      []string{"a.txt:1:TODO: a", "dir/c.txt:2:TODO: c", "dir/sub/e.txt:1:TODO: e"},
+		},
+		{
+			paths:    []string{"dir", "a.txt"},
+			maxDepth: 1,
+			exp:      []string{"a.txt:1:TODO: a", "dir/c.txt:2:TODO: c"},
+		},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetFS(fsys); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.EnableGitignore(true); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetMaxDepth(test.maxDepth); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Collect(test.paths...); err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, m := range w.Matches() {
+			out = append(out, m.String())
+		}
+		sort.Strings(out)
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("paths=%q: out=%q, exp=%q", test.paths, out, test.exp)
+		}
+	}
+
+	w := NewWalker()
+	if err := w.SetFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Collect("/a.txt"); err == nil {
+		t.Error("expected error for rooted path")
+	}
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i != 100; i++ {
+		fsys[fmt.Sprintf("dir%d/%02d.txt", i%4, i)] = &fstest.MapFile{Data: []byte("x\nTODO\n")}
+	}
+	w := NewWalker()
+	if err := w.SetMaxOpenFiles(0); err == nil {
+		t.Error("expected error for 0")
+	}
+	cfs := &countFS{fsys: fsys}
+	if err := w.SetFS(cfs); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkers(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMaxOpenFiles(2); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := w.Collect(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 100 {
+		t.Errorf("len(fs)=%d", len(fs))
+	}
+	if cfs.opened != 100 || cfs.maxOpen > 2 {
+		t.Errorf("opened=%d maxOpen=%d", cfs.opened, cfs.maxOpen)
+	}
+}
+
+func TestMaxOpenFilesDefault(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i != 3*defaultMaxOpenFiles; i++ {
+		fsys[fmt.Sprintf("%03d.txt", i)] = &fstest.MapFile{Data: []byte("TODO\n")}
+	}
+	cfs := &countFS{fsys: fsys}
+	w := NewWalker()
+	// opened files are counted while opening is slow
+	if err := w.SetFS(slowFS{FS: cfs, d: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkers(2 * defaultMaxOpenFiles); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := w.Collect(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 3*defaultMaxOpenFiles {
+		t.Errorf("len(fs)=%d", len(fs))
+	}
+	if cfs.maxOpen > defaultMaxOpenFiles {
+		t.Errorf("maxOpen=%d, exp %d or less", cfs.maxOpen, defaultMaxOpenFiles)
+	}
+}
+
+func TestCollectAllErrors(t *testing.T) {
+	fsys := denyFS{
+		FS: fstest.MapFS{
+			"a.txt":       {Data: []byte("TODO: a\n")},
+			"deny1/b.txt": {Data: []byte("TODO: b\n")},
+			"deny2/c.txt": {Data: []byte("TODO: c\n")},
+		},
+		deny: map[string]bool{"deny1": true, "deny2": true},
+	}
+	for _, collect := range []bool{false, true} {
+		w := NewWalker()
+		if err := w.SetFS(fsys); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.CollectAllErrors(collect); err != nil {
+			t.Fatal(err)
+		}
+		files, err := w.Collect(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("len(files)=%d", len(files))
+		}
+		var out []string
+		for _, err := range w.Errors() {
+			if !errors.Is(err, fs.ErrPermission) {
+				t.Errorf("unexpected error %v", err)
+			}
+			var perr *fs.PathError
+			if errors.As(err, &perr) {
+				out = append(out, perr.Path)
+			}
+		}
+		sort.Strings(out)
+		var exp []string
+		if collect {
+			exp = []string{"deny1", "deny2"}
+		}
+		if !reflect.DeepEqual(out, exp) {
+			t.Errorf("collect=%v: out=%q, exp=%q", collect, out, exp)
+		}
+	}
+}
+
+func TestSetDeadline(t *testing.T) {
+	mfs := fstest.MapFS{}
+	for i := 0; i != 50; i++ {
+		mfs[fmt.Sprintf("%02d.txt", i)] = &fstest.MapFile{Data: []byte("TODO\n")}
+	}
+	w := NewWalker()
+	if err := w.SetDeadline(-1); err == nil {
+		t.Error("expected error for negative duration")
+	}
+	if err := w.SetFS(slowFS{FS: mfs, d: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkers(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetDeadline(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	files, err := w.Collect(".")
+	if err != ErrDeadlineExceeded {
+		t.Errorf("err=%v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("elapsed %v", elapsed)
+	}
+	if len(files) == 0 || len(files) == 50 {
+		t.Errorf("len(files)=%d", len(files))
+	}
+
+	if err := w.SetDeadline(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetFS(mfs); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := w.Collect("."); err != nil || len(files) != 50 {
+		t.Errorf("len(files)=%d err=%v", len(files), err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.go":         &fstest.MapFile{Data: []byte("TODO\n")},
+		"b.txt":        &fstest.MapFile{Data: []byte("TODO\n")},
+		"sub/c.go":     &fstest.MapFile{Data: []byte("x\n")},
+		"vendor/d.go":  &fstest.MapFile{Data: []byte("TODO\n")},
+		"sub/e.go.txt": &fstest.MapFile{Data: []byte("TODO\n")},
+	}
+	cfs := &countFS{fsys: fsys}
+	w := NewWalker()
+	if err := w.SetFS(cfs); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetExtensions(".go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetExcludeDirs("vendor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetDryRun(true); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := w.Collect(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SortFiles(fs)
+	buf := new(bytes.Buffer)
+	if err := FprintCandidates(buf, fs...); err != nil {
+		t.Fatal(err)
+	}
+	if out, exp := buf.String(), "a.go\nsub/c.go\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+	for _, f := range fs {
+		if !f.Candidate() || len(f.Contexts) != 0 {
+			t.Errorf("%s: candidate=%v contexts=%d", f.Path, f.Candidate(), len(f.Contexts))
+		}
+	}
+	if cfs.opened != 0 {
+		t.Errorf("opened %d files", cfs.opened)
+	}
+}
+
+func TestSetCache(t *testing.T) {
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.txt":     &fstest.MapFile{Data: []byte("x\nTODO: a\ny\nTODO: b\n"), ModTime: mtime},
+		"b/c.txt":   &fstest.MapFile{Data: []byte("TODO: c\n"), ModTime: mtime},
+		"b/none.go": &fstest.MapFile{Data: []byte("none\n"), ModTime: mtime},
+	}
+	cachePath := filepath.Join(tempDir(t), "cache")
+	scan := func(pat string) (string, int) {
+		t.Helper()
+		cfs := &countFS{fsys: fsys}
+		w := NewWalker()
+		if err := w.SetFS(cfs); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetRegexp(pat); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetContext(1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetMergeContext(true); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetHighlight(true, "[", "]"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetCache(cachePath); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := w.Collect(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		SortFiles(fs)
+		buf := new(bytes.Buffer)
+		for _, f := range fs {
+			if err := f.FprintVerbose(buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := FprintFilesJSON(buf, fs...); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String(), cfs.opened
+	}
+
+	first, opened := scan("TODO")
+	if opened != 3 {
+		t.Errorf("first: opened=%d, exp 3", opened)
+	}
+	if !strings.Contains(first, "a.txt:2:[TODO]: a") {
+		t.Errorf("first: unexpected output:\n%s", first)
+	}
+	out, opened := scan("TODO")
+	if opened != 0 {
+		t.Errorf("unchanged: opened=%d, exp 0", opened)
+	}
+	if out != first {
+		t.Errorf("unchanged:\nout=%s\nexp=%s", out, first)
+	}
+
+	fsys["b/c.txt"] = &fstest.MapFile{Data: []byte("TODO: changed\n"), ModTime: mtime.Add(time.Second)}
+	out, opened = scan("TODO")
+	if opened != 1 {
+		t.Errorf("changed: opened=%d, exp 1", opened)
+	}
+	if !strings.Contains(out, "TODO: changed") {
+		t.Errorf("changed: result is not updated:\n%s", out)
+	}
+
+	if _, opened = scan("TODO: a"); opened != 3 {
+		t.Errorf("changed pattern: opened=%d, exp 3", opened)
+	}
+}
+
+func TestSetCacheOptions(t *testing.T) {
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.txt": &fstest.MapFile{Data: []byte("TODO: a\n"), ModTime: mtime},
+		"b.txt": &fstest.MapFile{Data: []byte("TODO: b\n"), ModTime: mtime},
+	}
+	cachePath := filepath.Join(tempDir(t), "cache")
+	scan := func(set func(w *Walker) error) int {
+		t.Helper()
+		cfs := &countFS{fsys: fsys}
+		w := NewWalker()
+		if err := w.SetFS(cfs); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetCache(cachePath); err != nil {
+			t.Fatal(err)
+		}
+		if err := set(w); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Collect("."); err != nil {
+			t.Fatal(err)
+		}
+		return cfs.opened
+	}
+	none := func(w *Walker) error { return nil }
+	gzip := func(w *Walker) error { return w.SetReadGzip(true) }
+	decode := func(w *Walker) error {
+		return w.SetDecoder(func(r io.Reader) io.Reader { return r })
+	}
+	tests := []struct {
+		name string
+		set  func(w *Walker) error
+		exp  int
+	}{
+		{name: "first", set: none, exp: 2},
+		{name: "unchanged", set: none, exp: 0},
+		{name: "gzip", set: gzip, exp: 2},
+		{name: "gzip unchanged", set: gzip, exp: 0},
+		{name: "decoder", set: decode, exp: 2},
+		{name: "decoder again", set: decode, exp: 2},
+	}
+	for _, test := range tests {
+		if opened := scan(test.set); opened != test.exp {
+			t.Errorf("%s: opened=%d, exp %d", test.name, opened, test.exp)
+		}
+	}
+}
+
+func TestSetCacheDedup(t *testing.T) {
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.txt": &fstest.MapFile{Data: []byte("TODO: same\n"), ModTime: mtime},
+		"b.txt": &fstest.MapFile{Data: []byte("TODO: same\n"), ModTime: mtime},
+		"c.txt": &fstest.MapFile{Data: []byte("TODO: c\n"), ModTime: mtime},
+	}
+	cachePath := filepath.Join(tempDir(t), "cache")
+	tests := []struct {
+		dedup bool
+		exp   int
+	}{
+		{dedup: false, exp: 3},
+		// entries of the scan without dedup are read again
+		{dedup: true, exp: 2},
+		{dedup: true, exp: 2},
+		{dedup: true, exp: 2},
+	}
+	for i, test := range tests {
+		w := NewWalker()
+		if err := w.SetFS(fsys); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetCache(cachePath); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetDedupByContent(test.dedup); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := w.Collect(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fs) != test.exp {
+			t.Errorf("%d: dedup=%v: files=%d, exp %d", i, test.dedup, len(fs), test.exp)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const gitignoreName = ".gitignore"
+
+type gitignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// gitignore is rules of one .gitignore file.
+// rules of nested .gitignore are layered over parent rules.
+type gitignore struct {
+	parent *gitignore
+	dir    string
+	rules  []*gitignoreRule
+}
+
+// loadGitignore reads .gitignore in dir.
+// if dir has not .gitignore then return parent.
+func (w *Walker) loadGitignore(parent *gitignore, dir string) (*gitignore, error) {
+	f, err := w.open(w.join(dir, gitignoreName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return parent, nil
+		}
+		return parent, err
+	}
+	defer f.Close()
+	return parseGitignore(parent, dir, f)
+}
+
+// parseGitignore reads rules of .gitignore in dir from r.
+func parseGitignore(parent *gitignore, dir string, r io.Reader) (*gitignore, error) {
+	g := &gitignore{parent: parent, dir: dir}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if r := parseGitignoreRule(sc.Text()); r != nil {
+			g.rules = append(g.rules, r)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return parent, err
+	}
+	if len(g.rules) == 0 {
+		return parent, nil
+	}
+	return g, nil
+}
+
+// parseGitignoreRule returns nil for blank lines and comments.
+func parseGitignoreRule(line string) *gitignoreRule {
+	line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	r := &gitignoreRule{}
+	switch {
+	case strings.HasPrefix(line, "!"):
+		r.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	r.pattern = line
+	return r
+}
+
+// ignored reports whether path is ignored.
+// nil *gitignore ignores nothing.
+func (g *gitignore) ignored(abs string, isDir bool) bool {
+	for ; g != nil; g = g.parent {
+		rel, err := filepath.Rel(g.dir, abs)
+		if err != nil || isOutside(rel) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		// the last matched rule is decisive.
+		for i := len(g.rules) - 1; i >= 0; i-- {
+			if g.rules[i].match(rel, isDir) {
+				return !g.rules[i].negate
+			}
+		}
+	}
+	return false
+}
+
+// isOutside reports whether rel of filepath.Rel is out of the base, names
+// that start with ".." e.g. "..secret" are inside.
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (r *gitignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	return matchGlob(r.pattern, path.Base(rel))
+}
+
+// matchGlob reports whether slash separated name matches pattern.
+// "**" in pattern matches zero or more directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pats, names []string) bool {
+	for len(pats) != 0 {
+		if pats[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(pats[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pats[0], names[0]); err != nil || !ok {
+			return false
+		}
+		pats, names = pats[1:], names[1:]
+	}
+	return len(names) == 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var matchGlobTests = []struct {
+	pattern, name string
+	exp           bool
+}{
+	{"*.o", "main.o", true},
+	{"*.o", "main.go", false},
+	{"a/*.o", "a/main.o", true},
+	{"a/*.o", "a/b/main.o", false},
+	{"**/foo", "foo", true},
+	{"**/foo", "a/b/foo", true},
+	{"a/**/b", "a/b", true},
+	{"a/**/b", "a/x/y/b", true},
+	{"a/**/b", "a/x/y/c", false},
+	{"a/**", "a/x/y", true},
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, test := range matchGlobTests {
+		if out := matchGlob(test.pattern, test.name); out != test.exp {
+			t.Errorf("matchGlob(%q, %q)=%v, exp %v", test.pattern, test.name, out, test.exp)
+		}
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		".gitignore":      "# comment\n*.log\n!keep.log\nbuild/\n/root.txt\n..secret\n",
+		"..secret":        "TODO\n",
+		"a.txt":           "TODO\n",
+		"a.log":           "TODO\n",
+		"keep.log":        "TODO\n",
+		"root.txt":        "TODO\n",
+		"build/a.txt":     "TODO\n",
+		"sub/root.txt":    "TODO\n",
+		"sub/b.log":       "TODO\n",
+		"sub/.gitignore":  "!b.log\n*.txt\n",
+		"sub/c.txt":       "TODO\n",
+		"other/build":     "TODO\n",
+		"other/d.txt":     "TODO\n",
+		"other/d.log.txt": "TODO\n",
+	})
+
+	tests := []struct {
+		enable bool
+		exp    []string
+	}{
+		{
+			enable: false,
+			exp: []string{
+				"..secret", ".gitignore", "a.log", "a.txt", "build/a.txt", "keep.log",
+				"other/build", "other/d.log.txt", "other/d.txt", "root.txt",
+				"sub/.gitignore", "sub/b.log", "sub/c.txt", "sub/root.txt",
+			},
+		},
+		{
+			enable: true,
+			exp: []string{
+				".gitignore", "a.txt", "keep.log",
+				"other/build", "other/d.log.txt", "other/d.txt",
+				"sub/.gitignore", "sub/b.log",
+			},
+		},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.EnableGitignore(test.enable); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("enable=%v:\nout=%q\nexp=%q", test.enable, out, test.exp)
+		}
+	}
+
+	// without .gitignore
+	plain := filepath.Join("testdata", "walker")
+	w := NewWalker()
+	if err := w.SetRegexp("word"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.EnableGitignore(true); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"dir/file.txt", "file.txt", "symlink/file.txt"}
+	if out := relPaths(t, plain, walk(t, w, plain)); !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
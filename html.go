@@ -0,0 +1,102 @@
+package main
+
+import (
+	"html/template"
+	"io"
+)
+
+const htmlReport = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+pre { margin: 0; }
+.num { color: gray; }
+.matched { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Files}}<details open>
+<summary>{{.Path}} ({{.Count}})</summary>
+{{range .Contexts}}<pre>
+{{range .}}<span class="num">{{.Num}}</span>{{if .Matched}}<span class="matched">:{{range .Parts}}{{if .Mark}}<mark>{{.Text}}</mark>{{else}}{{.Text}}{{end}}{{end}}</span>{{else}}-{{range .Parts}}{{.Text}}{{end}}{{end}}
+{{end}}</pre>
+<hr>
+{{end}}</details>
+{{end}}</body>
+</html>
+`
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(htmlReport))
+
+type htmlFile struct {
+	Path     string
+	Count    int
+	Contexts [][]*htmlLine
+}
+
+type htmlLine struct {
+	Num     uint
+	Matched bool
+	Parts   []htmlPart
+}
+
+type htmlPart struct {
+	Text string
+	Mark bool
+}
+
+// htmlParts splits s by locations of matched substrings.
+func htmlParts(s string, spans [][]int) []htmlPart {
+	var parts []htmlPart
+	var offset int
+	for _, span := range spans {
+		if span[0] == span[1] {
+			continue
+		}
+		if offset < span[0] {
+			parts = append(parts, htmlPart{Text: s[offset:span[0]]})
+		}
+		parts = append(parts, htmlPart{Text: s[span[0]:span[1]], Mark: true})
+		offset = span[1]
+	}
+	if offset < len(s) || len(parts) == 0 {
+		parts = append(parts, htmlPart{Text: s[offset:]})
+	}
+	return parts
+}
+
+// FprintFilesHTML writes fs as a HTML document titled title.
+// matched substrings are marked by highlighted locations if available,
+// otherwise the first matched location.
+func FprintFilesHTML(writer io.Writer, title string, fs ...*File) error {
+	data := struct {
+		Title string
+		Files []*htmlFile
+	}{Title: title}
+	for _, f := range fs {
+		hf := &htmlFile{Path: f.Path, Count: f.Count()}
+		for _, c := range f.Contexts {
+			var lines []*htmlLine
+			for i, l := range c.lines {
+				hl := &htmlLine{Num: l.Num}
+				if m := c.matchAt(i); m != nil {
+					spans := m.spans
+					if spans == nil && len(m.loc) == 2 {
+						spans = [][]int{m.loc}
+					}
+					hl.Matched = true
+					hl.Parts = htmlParts(l.Str, spans)
+				} else {
+					hl.Parts = htmlParts(l.Str, nil)
+				}
+				lines = append(lines, hl)
+			}
+			hf.Contexts = append(hf.Contexts, lines)
+		}
+		data.Files = append(data.Files, hf)
+	}
+	return htmlReportTemplate.Execute(writer, data)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFprintFilesHTML(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 0)
+	fr.hl = &highlight{}
+	f := readString(t, fr, "<script>alert(1)</script>\nif a < b && c { // TODO: 日本語 TODO\n")
+	f.Path = "a&b.go"
+
+	buf := new(bytes.Buffer)
+	if err := FprintFilesHTML(buf, "<report>", f); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, exp := range []string{
+		"<title>&lt;report&gt;</title>",
+		"<summary>a&amp;b.go (1)</summary>",
+		`<span class="num">1</span>-&lt;script&gt;alert(1)&lt;/script&gt;`,
+		`:if a &lt; b &amp;&amp; c { // <mark>TODO</mark>: 日本語 <mark>TODO</mark></span>`,
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("not contains %q:\n%s", exp, out)
+		}
+	}
+
+	// well-formed
+	d := xml.NewDecoder(buf)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	var depth, marks int
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if tok.Name.Local == "mark" {
+				marks++
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if depth != 0 || marks != 2 {
+		t.Errorf("depth=%d marks=%d", depth, marks)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+// Logger receives logs of Walker. it must be safe for concurrent use.
+type Logger interface {
+	// Debugf is called for each directories and files in the scan.
+	Debugf(format string, args ...interface{})
+	// Errorf is called for each errors in the scan.
+	Errorf(format string, args ...interface{})
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debugf(format string, args ...interface{}) {}
+func (discardLogger) Errorf(format string, args ...interface{}) {}
+
+// SetLogger sets l for logs of the scan, nil discards logs as default.
+func (w *Walker) SetLogger(l Logger) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if l == nil {
+		l = discardLogger{}
+	}
+	w.logger = l
+	return nil
+}
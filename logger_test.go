@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+type testLogger struct {
+	mu     sync.Mutex
+	debugs []string
+	errs   []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, fmt.Sprintf(format, args...))
+}
+
+func TestSetLogger(t *testing.T) {
+	fsys := denyFS{
+		FS: fstest.MapFS{
+			"a.txt":      {Data: []byte("TODO: a\n")},
+			"deny/b.txt": {Data: []byte("TODO: b\n")},
+		},
+		deny: map[string]bool{"deny": true},
+	}
+	l := &testLogger{}
+	w := NewWalker()
+	if err := w.SetFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetLogger(l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Collect("."); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(l.debugs)
+	if exp := []string{"dir .", "file a.txt"}; !reflect.DeepEqual(l.debugs, exp) {
+		t.Errorf("debugs=%q, exp=%q", l.debugs, exp)
+	}
+	if exp := []string{"open deny: permission denied"}; !reflect.DeepEqual(l.errs, exp) {
+		t.Errorf("errs=%q, exp=%q", l.errs, exp)
+	}
+
+	// discard
+	if err := w.SetLogger(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Collect("."); err != nil {
+		t.Fatal(err)
+	}
+}
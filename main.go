@@ -8,7 +8,10 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -22,15 +25,93 @@ const usage = `Usage:
   rgr [Options]
   rgr -- STRING
   rgr -- STRING [PATH...]
+  rgr -p STRING [-p STRING...] [PATH...]
+
+  PATH "-" is read from standard input.
+  PATH "@FILE" is a list of files, one path per line.
 
 Options:
   -help              Print this help
   -version           Print version
-  -verbose           Verbose output
+  -verbose           Verbose output, lines as "path:line:text"
+  -q, -quiet         Print nothing, exit status is 0 if matched, 1 if not, 2 on error
   -e, -regexp        Use regexp
+  -engine [NAME]     Regexp engine, "regexp" or "regexp2" if built with tag
+  -i, -ignore-case   Ignore case distinctions
+  -w, -word-regexp   Match only whole words
+  -x, -line-regexp   Match only whole lines
+  -v, -invert-match  Select non-matching lines
+  -match-path        Match base names of files too, as line 0
+  -allow-empty       Allow patterns that match every line, e.g. ".*"
+  -p, -pattern [STRING]
+                     Search for patterns independently, can repeat
   -C, -context [Num] With context
   -A, -after   [Num] Specify after lines
   -B, -before  [Num] Specify before lines
+  -merge             Merge adjacent contexts
+  -annotation [REGEXP]
+                     Extract "author" and "priority" named groups for JSON
+  -blame             Attach author and commit of matched lines by git blame for JSON
+  -enclosing [REGEXP]
+                     Print the nearest line before matched line that matched regexp
+  -exclude-dir [DIR,...]
+                     Skip directories by base name
+  -ext [EXT,...]     Search only files with extensions
+  -exclude-ext [EXT,...]
+                     Skip files with extensions, e.g. "min.js,map", wins over -ext
+  -glob [GLOB,...]   Search only files that matched globs, e.g. "src/**/*.go"
+  -include [REGEXP]  Search only files that path matched regexp
+  -exclude [REGEXP]  Skip files that path matched regexp
+  -gitignore         Skip files matched .gitignore
+  -follow            Follow symbolic links
+  -non-regular       Read named pipes and devices too
+  -skip-hidden       Skip hidden files and directories
+  -cache [FILE]      Reuse results of unchanged files from FILE, and update it
+  -since [TIME]      Search only files modified since RFC3339 time or duration ago, e.g. "24h"
+  -dedup             Skip files that have same content
+  -gzip              Decompress ".gz" files
+  -archive           Search files in ".zip", ".tar" and ".tar.gz"
+  -binary [MODE]     Binary file detection, "utf8", "nulbyte" or "off"
+  -max-size [Bytes]  Skip files larger than bytes
+  -file-timeout [Duration]
+                     Skip files that take longer than duration, e.g. "5s"
+  -deadline [Duration]
+                     Stop the scan after duration, e.g. "1m"
+  -max-depth [Num]   Limit depth of directories
+  -max-line [Bytes]  Max bytes of a line, default 64KB
+  -split-cr          Split lines at bare CR too
+  -multiline         Match patterns across lines
+  -multiline-max [Num]
+                     With -multiline, match files larger than Num bytes line by line
+  -workers [Num]     Number of workers
+  -ordered           Output in order of traversal with a worker, reproducible for -max-matches
+  -max-matches [Num] Stop after number of matched lines
+  -max-count [Num]   Stop reading a file after number of matched lines
+  -head [Num]        Search only first lines of each file
+  -tail [Num]        Search only last lines of each file
+  -sort              Sort output by path
+  -relative          Print paths relative from current directory
+  -color             Highlight matched substrings
+  -trim              Print lines without indentation
+  -column            Output as "path:line:column:text"
+  -line-base [Num]   Number of the first line, 0 or 1, default 1
+  -format [TEMPLATE] Output lines by text/template, e.g. "{{.Path}}:{{.Num}}:{{.Str}}"
+  -json              Output as JSON
+  -csv               Output matched lines as CSV
+  -markdown          Output as Markdown task lists, with contexts if -verbose
+  -html              Output as HTML document
+  -group             Output grouped by patterns of -p, or by matched text
+                     if there is one pattern
+  -l, -files-with-matches
+                     Output paths of matched files only
+  -L, -files-without-match
+                     Output paths of files that have no matched line
+  -dry-run           Output paths of files that would be searched without reading
+  -Z, -null          Output paths of matched files terminated by NUL
+  -c, -count         Print number of matched lines only
+  -tag-counts        Print number of matched lines for each pattern at last
+  -summary           Print numbers of files and matches and elapsed time at last
+  -count-zero        With -count, print files that has no matched line
 
 Examples:
   # search "func"
@@ -41,6 +122,12 @@ Examples:
 
   # with context
   $ rgr -C 3 "func" main.go vendor/
+
+  # search standard input
+  $ cat main.go | rgr "func" -
+
+  # search and annotate tags
+  $ rgr -p "TODO" -p "FIXME" main.go vendor/
 `
 
 func printUsage() {
@@ -54,8 +141,17 @@ var opt struct {
 	help    bool
 	version bool
 
-	verbose bool
-	regexp  bool
+	verbose    bool
+	quiet      bool
+	regexp     bool
+	engine     string
+	ignoreCase bool
+	matchPath  bool
+	fullLine   bool
+	allowEmpty bool
+	word       bool
+	invert     bool
+	patterns   listFlag
 
 	// TODO?
 	// %f
@@ -69,6 +165,63 @@ var opt struct {
 	context int
 	before  int
 	after   int
+	merge   bool
+
+	annotation string
+	enclosing  string
+	blame      bool
+
+	excludeDir string
+	ext        string
+	excludeExt string
+	glob       string
+	include    string
+	exclude    string
+	gitignore  bool
+	follow     bool
+	nonRegular bool
+	skipHidden bool
+	dedup      bool
+	gzip       bool
+	archive    bool
+	binary     string
+	maxSize    int64
+	timeout    time.Duration
+	deadline   time.Duration
+	since      string
+	cache      string
+	maxDepth   int
+	maxLine    int
+	splitCR    bool
+	multiline  bool
+	mlMax      int64
+	workers    int
+	ordered    bool
+	maxMatches int
+	maxCount   int
+	head       int
+	tail       int
+
+	sort      bool
+	relative  bool
+	color     bool
+	trim      bool
+	column    bool
+	lineBase  uint
+	format    string
+	json      bool
+	csv       bool
+	markdown  bool
+	html      bool
+	group     bool
+	names     bool
+	noMatch   bool
+	dryRun    bool
+	null      bool
+	count     bool
+	countZero bool
+	tagCounts bool
+	summary   bool
 }
 
 func init() {
@@ -76,8 +229,23 @@ func init() {
 	flag.BoolVar(&opt.version, "version", false, "Print version")
 
 	flag.BoolVar(&opt.verbose, "verbose", false, "Verbose output")
+	flag.BoolVar(&opt.quiet, "quiet", false, "Print nothing, exit status only")
+	flag.BoolVar(&opt.quiet, "q", false, "Alias of -quiet")
 	flag.BoolVar(&opt.regexp, "regexp", false, "Use regexp")
 	flag.BoolVar(&opt.regexp, "e", false, "Alias of -regexp")
+	flag.StringVar(&opt.engine, "engine", EngineRegexp, "Regexp engine")
+	flag.BoolVar(&opt.ignoreCase, "ignore-case", false, "Ignore case distinctions")
+	flag.BoolVar(&opt.ignoreCase, "i", false, "Alias of -ignore-case")
+	flag.BoolVar(&opt.word, "word-regexp", false, "Match only whole words")
+	flag.BoolVar(&opt.word, "w", false, "Alias of -word-regexp")
+	flag.BoolVar(&opt.fullLine, "line-regexp", false, "Match only whole lines")
+	flag.BoolVar(&opt.fullLine, "x", false, "Alias of -line-regexp")
+	flag.BoolVar(&opt.invert, "invert-match", false, "Select non-matching lines")
+	flag.BoolVar(&opt.invert, "v", false, "Alias of -invert-match")
+	flag.BoolVar(&opt.matchPath, "match-path", false, "Match base names of files too")
+	flag.BoolVar(&opt.allowEmpty, "allow-empty", false, "Allow patterns that match every line")
+	flag.Var(&opt.patterns, "pattern", "Search for patterns independently")
+	flag.Var(&opt.patterns, "p", "Alias of -pattern")
 
 	flag.IntVar(&opt.context, "context", 0, "Append context")
 	flag.IntVar(&opt.context, "C", 0, "Alias of -context")
@@ -87,6 +255,94 @@ func init() {
 
 	flag.IntVar(&opt.after, "after", 0, "Alias of -context")
 	flag.IntVar(&opt.after, "A", 0, "Alias of -after")
+	flag.BoolVar(&opt.merge, "merge", false, "Merge adjacent contexts")
+	flag.StringVar(&opt.annotation, "annotation", "", "Extract author and priority from matched lines")
+	flag.BoolVar(&opt.blame, "blame", false, "Attach git blame of matched lines")
+	flag.StringVar(&opt.enclosing, "enclosing", "", "Print the nearest line that matched regexp before matched line")
+
+	flag.StringVar(&opt.excludeDir, "exclude-dir", "", "Skip directories by base name")
+	flag.StringVar(&opt.ext, "ext", "", "Search only files with extensions")
+	flag.StringVar(&opt.excludeExt, "exclude-ext", "", "Skip files with extensions")
+	flag.StringVar(&opt.glob, "glob", "", "Search only files that matched globs")
+	flag.StringVar(&opt.include, "include", "", "Search only files that path matched regexp")
+	flag.StringVar(&opt.exclude, "exclude", "", "Skip files that path matched regexp")
+	flag.BoolVar(&opt.gitignore, "gitignore", false, "Skip files matched .gitignore")
+	flag.BoolVar(&opt.follow, "follow", false, "Follow symbolic links")
+	flag.BoolVar(&opt.nonRegular, "non-regular", false, "Read named pipes and devices too")
+	flag.BoolVar(&opt.skipHidden, "skip-hidden", false, "Skip hidden files and directories")
+	flag.BoolVar(&opt.dedup, "dedup", false, "Skip files that have same content")
+	flag.BoolVar(&opt.gzip, "gzip", false, "Decompress .gz files")
+	flag.BoolVar(&opt.archive, "archive", false, "Search files in .zip, .tar and .tar.gz")
+	flag.StringVar(&opt.binary, "binary", BinaryUTF8, "Binary file detection")
+	flag.Int64Var(&opt.maxSize, "max-size", 0, "Skip files larger than bytes")
+	flag.DurationVar(&opt.timeout, "file-timeout", 0, "Skip files that take longer than duration")
+	flag.DurationVar(&opt.deadline, "deadline", 0, "Stop the scan after duration")
+	flag.StringVar(&opt.since, "since", "", "Search only files modified since time")
+	flag.StringVar(&opt.cache, "cache", "", "Cache file of results")
+	flag.IntVar(&opt.maxDepth, "max-depth", -1, "Limit depth of directories")
+	flag.IntVar(&opt.maxLine, "max-line", 0, "Max bytes of a line")
+	flag.BoolVar(&opt.splitCR, "split-cr", false, "Split lines at bare CR too")
+	flag.BoolVar(&opt.multiline, "multiline", false, "Match patterns across lines")
+	flag.Int64Var(&opt.mlMax, "multiline-max", 0, "Max bytes of a file for -multiline")
+	flag.IntVar(&opt.workers, "workers", 0, "Number of workers")
+	flag.BoolVar(&opt.ordered, "ordered", false, "Output in order of traversal")
+	flag.IntVar(&opt.maxMatches, "max-matches", 0, "Stop after matched lines")
+	flag.IntVar(&opt.maxCount, "max-count", 0, "Stop reading a file after matched lines")
+	flag.IntVar(&opt.head, "head", 0, "Search only first lines of each file")
+	flag.IntVar(&opt.tail, "tail", 0, "Search only last lines of each file")
+
+	flag.BoolVar(&opt.sort, "sort", false, "Sort output by path")
+	flag.BoolVar(&opt.relative, "relative", false, "Print relative paths")
+	flag.BoolVar(&opt.color, "color", false, "Highlight matched substrings")
+	flag.BoolVar(&opt.trim, "trim", false, "Print lines without indentation")
+	flag.BoolVar(&opt.column, "column", false, "Output with column")
+	flag.UintVar(&opt.lineBase, "line-base", 1, "Number of the first line")
+	flag.StringVar(&opt.format, "format", "", "Output lines by text/template")
+	flag.BoolVar(&opt.json, "json", false, "Output as JSON")
+	flag.BoolVar(&opt.csv, "csv", false, "Output as CSV")
+	flag.BoolVar(&opt.markdown, "markdown", false, "Output as Markdown task lists")
+	flag.BoolVar(&opt.html, "html", false, "Output as HTML document")
+	flag.BoolVar(&opt.group, "group", false, "Output grouped by patterns")
+	flag.BoolVar(&opt.names, "files-with-matches", false, "Output paths of matched files only")
+	flag.BoolVar(&opt.names, "l", false, "Alias of -files-with-matches")
+	flag.BoolVar(&opt.noMatch, "files-without-match", false, "Output paths of files that have no matched line")
+	flag.BoolVar(&opt.noMatch, "L", false, "Alias of -files-without-match")
+	flag.BoolVar(&opt.dryRun, "dry-run", false, "Output paths of files that would be searched")
+	flag.BoolVar(&opt.null, "null", false, "Output paths terminated by NUL")
+	flag.BoolVar(&opt.null, "Z", false, "Alias of -null")
+	flag.BoolVar(&opt.count, "count", false, "Print number of matched lines only")
+	flag.BoolVar(&opt.count, "c", false, "Alias of -count")
+	flag.BoolVar(&opt.summary, "summary", false, "Print summary of the scan at last")
+	flag.BoolVar(&opt.tagCounts, "tag-counts", false, "Print number of matched lines for each pattern")
+	flag.BoolVar(&opt.countZero, "count-zero", false, "Print files that has no matched line with -count")
+}
+
+// listFlag is flag.Value for repeatable string flag.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// splitList splits comma separated flag value.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// parseSince parses RFC3339 time, or duration before now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
 }
 
 func run() (err error) {
@@ -101,18 +357,56 @@ func run() (err error) {
 		_, err = fmt.Printf("%s %s\n", Name, Version)
 		return err
 	}
-	if flag.NArg() == 0 {
-		flag.Usage()
-		return errors.New("arguments not enough")
+	tags := []string(opt.patterns)
+	paths := flag.Args()
+	if len(tags) == 0 {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			return errors.New("arguments not enough")
+		}
+		tags = paths[:1]
+		paths = paths[1:]
 	}
 
 	walker := NewWalker()
 
-	pat := flag.Arg(0)
-	if !opt.regexp {
-		pat = regexp.QuoteMeta(pat)
+	if err = walker.SetLiteral(!opt.regexp); err != nil {
+		return err
+	}
+	if err = walker.SetRegexpEngine(opt.engine); err != nil {
+		return err
+	}
+	if err = walker.SetIgnoreCase(opt.ignoreCase); err != nil {
+		return err
+	}
+	if err = walker.SetWholeWord(opt.word); err != nil {
+		return err
+	}
+	if err = walker.SetFullLine(opt.fullLine); err != nil {
+		return err
 	}
-	if err = walker.SetRegexp(pat); err != nil {
+	if err = walker.SetRegexps(tags...); err != nil {
+		return err
+	}
+	if err = walker.SetInvertMatch(opt.invert); err != nil {
+		return err
+	}
+	if err = walker.AllowEmptyMatch(opt.allowEmpty); err != nil {
+		return err
+	}
+	if err = walker.SetMatchPath(opt.matchPath); err != nil {
+		return err
+	}
+	if err = walker.SetHighlight(opt.color, "\x1b[31m", "\x1b[0m"); err != nil {
+		return err
+	}
+	if err = walker.SetTrimIndent(opt.trim); err != nil {
+		return err
+	}
+	if err = walker.SetLineBase(opt.lineBase); err != nil {
+		return err
+	}
+	if err = walker.SetOutputTemplate(opt.format); err != nil {
 		return err
 	}
 
@@ -128,6 +422,185 @@ func run() (err error) {
 	if err = walker.SetContext(opt.before, opt.after); err != nil {
 		return err
 	}
+	if err = walker.SetMergeContext(opt.merge); err != nil {
+		return err
+	}
+	if err = walker.SetCountOnly(opt.count); err != nil {
+		return err
+	}
+	if err = walker.SetDryRun(opt.dryRun); err != nil {
+		return err
+	}
+	if err = walker.SetNamesOnly((opt.names || opt.noMatch || opt.null) && !opt.count); err != nil {
+		return err
+	}
+	if opt.annotation != "" {
+		re, err := regexp.Compile(opt.annotation)
+		if err != nil {
+			return err
+		}
+		if err = walker.SetAnnotationPattern(re); err != nil {
+			return err
+		}
+	}
+	if err = walker.SetGitBlame(opt.blame); err != nil {
+		return err
+	}
+	if opt.enclosing != "" {
+		re, err := regexp.Compile(opt.enclosing)
+		if err != nil {
+			return err
+		}
+		if err = walker.SetShowEnclosing(re); err != nil {
+			return err
+		}
+	}
+
+	if opt.excludeDir != "" {
+		if err = walker.SetExcludeDirs(splitList(opt.excludeDir)...); err != nil {
+			return err
+		}
+	}
+
+	if opt.ext != "" {
+		if err = walker.SetExtensions(splitList(opt.ext)...); err != nil {
+			return err
+		}
+	}
+	if opt.excludeExt != "" {
+		if err = walker.SetExcludeExtensions(splitList(opt.excludeExt)...); err != nil {
+			return err
+		}
+	}
+
+	if opt.glob != "" {
+		if err = walker.SetGlobs(splitList(opt.glob)...); err != nil {
+			return err
+		}
+	}
+
+	if opt.include != "" || opt.exclude != "" {
+		var include, exclude *regexp.Regexp
+		if opt.include != "" {
+			if include, err = regexp.Compile(opt.include); err != nil {
+				return err
+			}
+		}
+		if opt.exclude != "" {
+			if exclude, err = regexp.Compile(opt.exclude); err != nil {
+				return err
+			}
+		}
+		if err = walker.SetPathFilter(include, exclude); err != nil {
+			return err
+		}
+	}
+
+	if err = walker.EnableGitignore(opt.gitignore); err != nil {
+		return err
+	}
+
+	if err = walker.SetFollowSymlinks(opt.follow); err != nil {
+		return err
+	}
+
+	if err = walker.SetIncludeNonRegular(opt.nonRegular); err != nil {
+		return err
+	}
+
+	if err = walker.SetSkipHidden(opt.skipHidden); err != nil {
+		return err
+	}
+
+	if err = walker.SetBinaryDetection(opt.binary); err != nil {
+		return err
+	}
+
+	if err = walker.SetReadGzip(opt.gzip); err != nil {
+		return err
+	}
+	if err = walker.SetReadArchive(opt.archive); err != nil {
+		return err
+	}
+
+	if err = walker.SetDedupByContent(opt.dedup); err != nil {
+		return err
+	}
+
+	if err = walker.SetMaxFileSize(opt.maxSize); err != nil {
+		return err
+	}
+
+	if err = walker.SetFileTimeout(opt.timeout); err != nil {
+		return err
+	}
+	if err = walker.SetDeadline(opt.deadline); err != nil {
+		return err
+	}
+	if err = walker.SetCache(opt.cache); err != nil {
+		return err
+	}
+	if opt.since != "" {
+		since, err := parseSince(opt.since)
+		if err != nil {
+			return err
+		}
+		if err = walker.SetModifiedSince(since); err != nil {
+			return err
+		}
+	}
+
+	if err = walker.SetMaxDepth(opt.maxDepth); err != nil {
+		return err
+	}
+	if err = walker.SetMaxLineSize(opt.maxLine); err != nil {
+		return err
+	}
+	if err = walker.SetSplitCR(opt.splitCR); err != nil {
+		return err
+	}
+	if err = walker.SetMultiline(opt.multiline); err != nil {
+		return err
+	}
+	if err = walker.SetMultilineMaxBytes(opt.mlMax); err != nil {
+		return err
+	}
+
+	if opt.relative {
+		pwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if err = walker.SetBasePath(pwd); err != nil {
+			return err
+		}
+	}
+
+	if opt.quiet {
+		// the first match decides exit status
+		opt.maxMatches = 1
+	}
+	if err = walker.SetMaxMatches(opt.maxMatches); err != nil {
+		return err
+	}
+	if err = walker.SetMaxMatchesPerFile(opt.maxCount); err != nil {
+		return err
+	}
+	if err = walker.SetHeadLines(opt.head); err != nil {
+		return err
+	}
+	if err = walker.SetTailLines(opt.tail); err != nil {
+		return err
+	}
+
+	if opt.workers != 0 {
+		if err = walker.SetWorkers(opt.workers); err != nil {
+			return err
+		}
+	}
+	if err = walker.SetOrdered(opt.ordered); err != nil {
+		return err
+	}
 
 	var rwm sync.RWMutex
 	if opt.verbose {
@@ -142,9 +615,11 @@ func run() (err error) {
 		}
 	}
 
-	fileQueue, wait := walker.Start()
+	fileQueue, wait, err := walker.Start()
+	if err != nil {
+		return err
+	}
 
-	paths := flag.Args()[1:]
 	if len(paths) == 0 {
 		pwd, err := os.Getwd()
 		if err != nil {
@@ -152,35 +627,173 @@ func run() (err error) {
 		}
 		paths = append(paths, pwd)
 	}
-	if err = walker.SendPath(paths...); err != nil {
-		return err
-	}
+	// paths are sent while results are received, the queues are bounded.
+	sendErr := make(chan error, 1)
+	go func() { sendErr <- sendPaths(walker, wait, paths) }()
 
-	go wait()
-	var f *File
-	var c *Context
-	for f = range fileQueue {
-		if len(f.Contexts) == 0 {
-			continue
-		}
+	printFile := func(f *File) error {
 		rwm.Lock()
+		defer rwm.Unlock()
+		if opt.count {
+			return FprintCounts(os.Stdout, opt.countZero, f)
+		}
+		if opt.column {
+			return FprintColumns(os.Stdout, f)
+		}
+		if opt.names {
+			return FprintFileNames(os.Stdout, f)
+		}
+		if opt.format != "" {
+			return FprintTemplate(os.Stdout, walker.OutputTemplate(), f)
+		}
+		if opt.verbose {
+			return f.FprintVerbose(os.Stdout)
+		}
 		fmt.Println(f.Path)
-		for _, c = range f.Contexts {
+		for _, c := range f.Contexts {
+			if len(tags) > 1 {
+				fmt.Print(c.TaggedString(tags))
+				continue
+			}
 			fmt.Print(c)
 		}
 		fmt.Println()
-		rwm.Unlock()
+		return nil
+	}
+
+	var fs []*File
+	for f := range fileQueue {
+		if opt.quiet || f.Count() == 0 && !f.Candidate() && !(opt.count && opt.countZero) {
+			continue
+		}
+		if opt.dryRun || opt.json || opt.csv || opt.markdown || opt.html || opt.group || opt.noMatch || opt.null || opt.sort {
+			fs = append(fs, f)
+			continue
+		}
+		if err = printFile(f); err != nil {
+			return err
+		}
+	}
+	if err = <-sendErr; err != nil {
+		return err
 	}
 
+	if opt.quiet {
+		if err = walker.Err(); err != nil {
+			return err
+		}
+		if !walker.HadMatches() {
+			return errNoMatch
+		}
+		return nil
+	}
+
+	if opt.sort {
+		SortFiles(fs)
+	}
+	switch {
+	case opt.dryRun:
+		if err = FprintCandidates(os.Stdout, fs...); err != nil {
+			return err
+		}
+	case opt.json:
+		if err = FprintFilesJSON(os.Stdout, fs...); err != nil {
+			return err
+		}
+	case opt.csv:
+		if err = FprintFilesCSV(os.Stdout, fs...); err != nil {
+			return err
+		}
+	case opt.markdown:
+		if err = FprintFilesMarkdown(os.Stdout, opt.verbose, fs...); err != nil {
+			return err
+		}
+	case opt.html:
+		title := fmt.Sprintf("%s %s", Name, strings.Join(tags, ", "))
+		if err = FprintFilesHTML(os.Stdout, title, fs...); err != nil {
+			return err
+		}
+	case opt.group:
+		if err = FprintGroupedByTag(os.Stdout, tags, fs...); err != nil {
+			return err
+		}
+	case opt.noMatch:
+		scanned := walker.Scanned()
+		if opt.sort {
+			sort.Slice(scanned, func(i, j int) bool { return lessPath(scanned[i], scanned[j]) })
+		}
+		if err = FprintFilesWithoutMatch(os.Stdout, scanned, fs); err != nil {
+			return err
+		}
+	case opt.null:
+		if err = FprintFilesZero(os.Stdout, fs...); err != nil {
+			return err
+		}
+	default:
+		for _, f := range fs {
+			if err = printFile(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opt.tagCounts {
+		if err = FprintTagSummary(os.Stdout, walker.TagCounts()); err != nil {
+			return err
+		}
+	}
+	if opt.summary {
+		if err = FprintSummary(os.Stdout, walker.Stats(), walker.Elapsed()); err != nil {
+			return err
+		}
+	}
+
+	if err = walker.Err(); err != nil {
+		return err
+	}
 	if walker.WaitExitCode() != 0 {
 		return errors.New("internal error")
 	}
 	return nil
 }
 
+// errNoMatch is exit status 1 of -quiet.
+var errNoMatch = errors.New("no match")
+
 func main() {
-	if err := run(); err != nil {
+	err := run()
+	switch {
+	case err == nil:
+	case err == errNoMatch:
+		os.Exit(1)
+	case opt.quiet:
+		fmt.Fprintf(os.Stderr, "%s:[Err]:%v\n", Name, err)
+		os.Exit(2)
+	default:
 		fmt.Fprintf(os.Stderr, "%s:[Err]:%v\n", Name, err)
 		os.Exit(1)
 	}
 }
+
+// sendPaths sends paths to walker then calls wait, "@FILE" of paths is a
+// list of paths.
+func sendPaths(walker *Walker, wait func(), paths []string) error {
+	defer wait()
+	var rest []string
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "@") || len(p) == 1 {
+			rest = append(rest, p)
+			continue
+		}
+		list, err := os.Open(p[1:])
+		if err != nil {
+			return err
+		}
+		err = walker.SendPathList(list)
+		list.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return walker.SendPath(rest...)
+}
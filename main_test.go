@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendPathsManifest(t *testing.T) {
+	paths := manyPaths(t, 600)
+	manifest := filepath.Join(tempDir(t), "manifest")
+	if err := ioutil.WriteFile(manifest, []byte(strings.Join(paths, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	rq, wait, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendErr := make(chan error, 1)
+	go func() { sendErr <- sendPaths(w, wait, []string{"@" + manifest}) }()
+	var n int
+	withTimeout(t, 10*time.Second, func() {
+		for f := range rq {
+			if f.Count() != 0 {
+				n++
+			}
+		}
+	})
+	if err := <-sendErr; err != nil {
+		t.Fatal(err)
+	}
+	if n != len(paths) {
+		t.Errorf("files=%d, exp %d", n, len(paths))
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Matcher selects lines.
+type Matcher interface {
+	// Match reports whether line contains any match.
+	Match(line string) bool
+}
+
+// IndexMatcher is a Matcher that reports locations of matched substrings.
+// location is used for column and highlight, Matcher that is not
+// IndexMatcher is treated as matched at the head of the line.
+type IndexMatcher interface {
+	Matcher
+
+	// FindAllIndex returns at most n locations of successive matches,
+	// negative n is all. nil is not matched.
+	FindAllIndex(line string, n int) [][]int
+}
+
+// RegexpMatcher matches lines by regexp.
+type RegexpMatcher struct {
+	re *regexp.Regexp
+}
+
+func NewRegexpMatcher(re *regexp.Regexp) *RegexpMatcher {
+	return &RegexpMatcher{re: re}
+}
+
+func (m *RegexpMatcher) Match(line string) bool {
+	return m.re.MatchString(line)
+}
+
+func (m *RegexpMatcher) FindAllIndex(line string, n int) [][]int {
+	return m.re.FindAllStringIndex(line, n)
+}
+
+// LiteralMatcher matches lines that contain the fixed string.
+type LiteralMatcher string
+
+func (m LiteralMatcher) Match(line string) bool {
+	return strings.Contains(line, string(m))
+}
+
+func (m LiteralMatcher) FindAllIndex(line string, n int) [][]int {
+	if n == 0 {
+		return nil
+	}
+	if m == "" {
+		return [][]int{{0, 0}}
+	}
+	var locs [][]int
+	for offset := 0; n < 0 || len(locs) < n; {
+		i := strings.Index(line[offset:], string(m))
+		if i < 0 {
+			break
+		}
+		i += offset
+		offset = i + len(m)
+		locs = append(locs, []int{i, offset})
+	}
+	return locs
+}
+
+// wordMatcher selects matches of m that are whole words, it is used for
+// LiteralMatcher. matches of m are not overlapped, then a whole word that
+// overlaps a preceding match of m is not found.
+type wordMatcher struct {
+	m IndexMatcher
+}
+
+func (m *wordMatcher) Match(line string) bool {
+	return len(m.FindAllIndex(line, 1)) != 0
+}
+
+func (m *wordMatcher) FindAllIndex(line string, n int) [][]int {
+	if n == 0 {
+		return nil
+	}
+	var locs [][]int
+	for _, loc := range m.m.FindAllIndex(line, -1) {
+		if !isWordBoundary(line, loc) {
+			continue
+		}
+		locs = append(locs, loc)
+		if len(locs) == n {
+			break
+		}
+	}
+	return locs
+}
+
+// nonWordClass matches a character that is not a word character, \b of
+// regexp is not used since it is ASCII only.
+const nonWordClass = `[^\pL\pN_]`
+
+// wordRegexpMatcher matches whole words by the pattern wrapped with
+// boundaries, a boundary is an end of the line or nonWordClass.
+type wordRegexpMatcher struct {
+	// the first match that may be at the beginning of the line.
+	head *regexp.Regexp
+
+	// successive matches that are searched from the preceding character.
+	rest *regexp.Regexp
+}
+
+// compileWordRegexp compiles pat that matches only whole words, pat is
+// wrapped as a whole after it is compiled alone.
+func compileWordRegexp(pat string) (*wordRegexpMatcher, error) {
+	if _, err := regexp.Compile(pat); err != nil {
+		return nil, err
+	}
+	tail := `(?:` + nonWordClass + `|\z)`
+	head, err := regexp.Compile(`(?:\A|` + nonWordClass + `)(` + pat + `)` + tail)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := regexp.Compile(nonWordClass + `(` + pat + `)` + tail)
+	if err != nil {
+		return nil, err
+	}
+	return &wordRegexpMatcher{head: head, rest: rest}, nil
+}
+
+func (m *wordRegexpMatcher) Match(line string) bool {
+	return m.head.MatchString(line)
+}
+
+func (m *wordRegexpMatcher) FindAllIndex(line string, n int) [][]int {
+	var locs [][]int
+	re, offset := m.head, 0
+	for n < 0 || len(locs) < n {
+		loc := re.FindStringSubmatchIndex(line[offset:])
+		if loc == nil {
+			break
+		}
+		start, end := offset+loc[2], offset+loc[3]
+		locs = append(locs, []int{start, end})
+		re, offset = m.rest, end
+		if start == end {
+			// the character at end is the boundary of the next match
+			if end == len(line) {
+				break
+			}
+			continue
+		}
+		_, size := utf8.DecodeLastRuneInString(line[:end])
+		offset -= size
+	}
+	return locs
+}
+
+// isWordBoundary reports whether loc of s is not adjoined word characters.
+func isWordBoundary(s string, loc []int) bool {
+	if r, _ := utf8.DecodeLastRuneInString(s[:loc[0]]); loc[0] != 0 && isWordRune(r) {
+		return false
+	}
+	if r, _ := utf8.DecodeRuneInString(s[loc[1]:]); loc[1] != len(s) && isWordRune(r) {
+		return false
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// findIndex returns location of the first match in s, or nil.
+func findIndex(m Matcher, s string) []int {
+	if im, ok := m.(IndexMatcher); ok {
+		if locs := im.FindAllIndex(s, 1); len(locs) != 0 {
+			return locs[0]
+		}
+		return nil
+	}
+	if m.Match(s) {
+		return []int{0, 0}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLiteralMatcher(t *testing.T) {
+	tests := []struct {
+		lit, line string
+		n         int
+		exp       [][]int
+	}{
+		{lit: "TODO", line: "none", n: -1, exp: nil},
+		{lit: "TODO", line: "a TODO b TODO", n: -1, exp: [][]int{{2, 6}, {9, 13}}},
+		{lit: "TODO", line: "a TODO b TODO", n: 1, exp: [][]int{{2, 6}}},
+		{lit: "aa", line: "aaa", n: -1, exp: [][]int{{0, 2}}},
+		{lit: "a.b", line: "axb a.b", n: -1, exp: [][]int{{4, 7}}},
+		{lit: "", line: "a", n: -1, exp: [][]int{{0, 0}}},
+	}
+	for _, test := range tests {
+		m := LiteralMatcher(test.lit)
+		out := m.FindAllIndex(test.line, test.n)
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("lit=%q line=%q n=%d: out=%v, exp=%v", test.lit, test.line, test.n, out, test.exp)
+		}
+		if m.Match(test.line) != (test.exp != nil) {
+			t.Errorf("lit=%q line=%q: Match=%v", test.lit, test.line, !(test.exp != nil))
+		}
+	}
+}
+
+// prefixMatcher is a Matcher that is not IndexMatcher.
+type prefixMatcher string
+
+func (m prefixMatcher) Match(line string) bool {
+	return strings.HasPrefix(line, string(m))
+}
+
+func TestMatcherFileReader(t *testing.T) {
+	str := "TODO: a\nb TODO\n"
+	tests := []struct {
+		m   Matcher
+		exp string
+	}{
+		{m: NewRegexpMatcher(regexp.MustCompile("TODO")), exp: "1:[TODO]: a\n2:b [TODO]\n"},
+		{m: LiteralMatcher("TODO"), exp: "1:[TODO]: a\n2:b [TODO]\n"},
+		{m: prefixMatcher("TODO"), exp: "1:TODO: a\n"},
+	}
+	for _, test := range tests {
+		fr := NewMatcherFileReader([]Matcher{test.m}, 0, 0)
+		fr.hl = &highlight{start: "[", end: "]"}
+		f := readString(t, fr, str)
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp {
+			t.Errorf("%T: out=%q, exp=%q", test.m, out, test.exp)
+		}
+	}
+}
+
+func TestWalkerSetMatcher(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO: a\nb TODO\n",
+	})
+	w := NewWalker()
+	if err := w.SetMatcher(prefixMatcher("TODO")); err != nil {
+		t.Fatal(err)
+	}
+	// matching options are not applied to the matcher
+	if err := w.SetIgnoreCase(true); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := w.Collect(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].Count() != 1 {
+		t.Errorf("out=%+v", fs)
+	}
+}
+
+func TestWalkerSetLiteral(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "a.b\naxb\nA.B\n",
+	})
+	tests := []struct {
+		literal, ignoreCase bool
+		exp                 int
+	}{
+		{literal: false, ignoreCase: false, exp: 2},
+		{literal: true, ignoreCase: false, exp: 1},
+		{literal: true, ignoreCase: true, exp: 2},
+		{literal: false, ignoreCase: true, exp: 3},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("a.b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetLiteral(test.literal); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetIgnoreCase(test.ignoreCase); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := w.Collect(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out int
+		for _, f := range fs {
+			out += f.Count()
+		}
+		if out != test.exp {
+			t.Errorf("literal=%v ignoreCase=%v: out=%d, exp %d", test.literal, test.ignoreCase, out, test.exp)
+		}
+	}
+}
+
+func TestWalkerSetFullLine(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO\n// TODO: x\ntodo\nTODO.\nTODOx\nTODO|FIXME\nFIXME\n",
+	})
+	tests := []struct {
+		pat                            string
+		literal, ignoreCase, wholeWord bool
+		exp                            []string
+	}{
+		{pat: "TODO", literal: true, exp: []string{"TODO"}},
+		{pat: "TODO", literal: true, ignoreCase: true, exp: []string{"TODO", "todo"}},
+		{pat: "TODO", literal: true, wholeWord: true, exp: []string{"TODO"}},
+		{pat: "TODO.", literal: true, exp: []string{"TODO."}},
+		{pat: "TODO.", exp: []string{"TODO.", "TODOx"}},
+		{pat: "TODO|FIXME", exp: []string{"TODO", "FIXME"}},
+		{pat: "TODO|FIXME", literal: true, exp: []string{"TODO|FIXME"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp(test.pat); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetLiteral(test.literal); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetIgnoreCase(test.ignoreCase); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetWholeWord(test.wholeWord); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetFullLine(true); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := w.Collect(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, f := range fs {
+			for _, m := range f.Matches() {
+				out = append(out, m.Text)
+			}
+		}
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("pat=%q literal=%v ignoreCase=%v wholeWord=%v: out=%q, exp=%q",
+				test.pat, test.literal, test.ignoreCase, test.wholeWord, out, test.exp)
+		}
+	}
+}
+
+// benchText is large text that matches sparse.
+var benchText = strings.Repeat(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 99)+"// TODO: fix\n", 1000)
+
+func benchmarkMatcher(b *testing.B, m Matcher) {
+	b.SetBytes(int64(len(benchText)))
+	for i := 0; i < b.N; i++ {
+		fr := NewMatcherFileReader([]Matcher{m}, 0, 0)
+		f, err := fr.Read("bench", strings.NewReader(benchText))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if f.Count() != 1000 {
+			b.Fatalf("count=%d", f.Count())
+		}
+	}
+}
+
+func BenchmarkLiteralMatcher(b *testing.B) {
+	benchmarkMatcher(b, LiteralMatcher("TODO"))
+}
+
+func BenchmarkRegexpMatcher(b *testing.B) {
+	benchmarkMatcher(b, NewRegexpMatcher(regexp.MustCompile("TODO")))
+}
+
+func TestWalkerSetWholeWord(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "// TODO: x\nTODOLIST\nPROTODOG\nTODOS\n",
+	})
+	tests := []struct {
+		pat     string
+		literal bool
+		exp     []string
+	}{
+		{pat: "TODO", literal: true, exp: []string{"// TODO: x"}},
+		{pat: "TODO", exp: []string{"// TODO: x"}},
+		{pat: "TODO|TODOS", exp: []string{"// TODO: x", "TODOS"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp(test.pat); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetLiteral(test.literal); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetWholeWord(true); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := w.Collect(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, f := range fs {
+			for _, m := range f.Matches() {
+				out = append(out, m.Text)
+			}
+		}
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("pat=%q literal=%v: out=%q, exp=%q", test.pat, test.literal, out, test.exp)
+		}
+	}
+}
+
+func TestWordRegexpMatcher(t *testing.T) {
+	tests := []struct {
+		pat, line string
+		exp       [][]int
+		err       bool
+	}{
+		{pat: "TODO", line: "// TODO: x", exp: [][]int{{3, 7}}},
+		{pat: "TODO", line: "TODOLIST", exp: nil},
+		{pat: "TODO", line: "PROTODOG", exp: nil},
+		{pat: "TODO", line: "TODO_x TODO", exp: [][]int{{7, 11}}},
+		{pat: "TODO", line: "あTODO TODOé (TODO)", exp: [][]int{{16, 20}}},
+		{pat: "é", line: "aé é", exp: [][]int{{4, 6}}},
+		{pat: `TODO|FIX`, line: "FIXME TODO", exp: [][]int{{6, 10}}},
+		{pat: `\w+\(`, line: "f( g(", exp: [][]int{{0, 2}, {3, 5}}},
+		{pat: `TODO|TODOS`, line: "TODOS", exp: [][]int{{0, 5}}},
+		{pat: `TODO|TODOS`, line: "TODO TODOS TODOSx", exp: [][]int{{0, 4}, {5, 10}}},
+		{pat: `(?i)todo`, line: "TODO todo", exp: [][]int{{0, 4}, {5, 9}}},
+		{pat: `a)|(b`, line: "a b", exp: nil, err: true},
+		{pat: `x*`, line: "ab", exp: nil},
+		{pat: `x*`, line: "a  b", exp: [][]int{{2, 2}}},
+	}
+	for _, test := range tests {
+		m, err := compileWordRegexp(test.pat)
+		if (err != nil) != test.err {
+			t.Errorf("pat=%q: err=%v", test.pat, err)
+		}
+		if err != nil {
+			continue
+		}
+		out := m.FindAllIndex(test.line, -1)
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("pat=%q line=%q: out=%v, exp=%v", test.pat, test.line, out, test.exp)
+		}
+		if m.Match(test.line) != (test.exp != nil) {
+			t.Errorf("pat=%q line=%q: Match=%v", test.pat, test.line, test.exp == nil)
+		}
+	}
+}
+
+func TestWordMatcher(t *testing.T) {
+	tests := []struct {
+		pat, line string
+		exp       [][]int
+	}{
+		{pat: "TODO", line: "// TODO: x", exp: [][]int{{3, 7}}},
+		{pat: "TODO", line: "TODOLIST PROTODOG", exp: nil},
+		{pat: "TODO", line: "あTODO TODOé (TODO)", exp: [][]int{{16, 20}}},
+		{pat: "a.b", line: "a.bc a.b", exp: [][]int{{5, 8}}},
+	}
+	for _, test := range tests {
+		m := &wordMatcher{m: LiteralMatcher(test.pat)}
+		if out := m.FindAllIndex(test.line, -1); !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("pat=%q line=%q: out=%v, exp=%v", test.pat, test.line, out, test.exp)
+		}
+	}
+}
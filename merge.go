@@ -0,0 +1,63 @@
+package main
+
+// mergeContexts merges contexts that lines are overlapped or adjacent.
+// cs must be sorted by line number.
+func mergeContexts(cs []*Context) []*Context {
+	var merged []*Context
+	for _, c := range cs {
+		if n := len(merged); n != 0 {
+			start, _ := c.Range()
+			if _, end := merged[n-1].Range(); start <= end+1 {
+				merged[n-1] = merged[n-1].merge(c)
+				continue
+			}
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// merge returns new context that is concatenated c and next.
+func (c *Context) merge(next *Context) *Context {
+	m := *c
+	m.lines = append([]*Line{}, c.lines...)
+	m.merged = append([]*Context{}, c.merged...)
+	m.mergedIndex = append([]int{}, c.mergedIndex...)
+
+	_, last := c.Range()
+	for _, l := range next.lines {
+		if l.Num > last {
+			m.lines = append(m.lines, l)
+		}
+	}
+	// lines are continuous, index is offset from the first line
+	for _, sub := range next.matches() {
+		m.merged = append(m.merged, sub)
+		m.mergedIndex = append(m.mergedIndex, int(sub.lines[sub.index].Num-m.lines[0].Num))
+	}
+	return &m
+}
+
+// matches returns c and matched contexts that merged into c.
+func (c *Context) matches() []*Context {
+	if len(c.merged) == 0 {
+		return []*Context{c}
+	}
+	sub := *c
+	sub.merged = nil
+	sub.mergedIndex = nil
+	return append([]*Context{&sub}, c.merged...)
+}
+
+// matchAt returns matched context of c.lines[i], or nil if not matched.
+func (c *Context) matchAt(i int) *Context {
+	if i == c.index {
+		return c
+	}
+	for j, index := range c.mergedIndex {
+		if index == i {
+			return c.merged[j]
+		}
+	}
+	return nil
+}
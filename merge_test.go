@@ -0,0 +1,85 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var mergeContextsTests = []struct {
+	str             string
+	nbefore, nafter int
+
+	exp string
+	n   int // number of contexts
+}{
+	// 1 line apart
+	{
+		str:     "1\n2 TODO\n3\n4 TODO\n5\n",
+		nbefore: 1,
+		nafter:  1,
+		exp:     "1-1\n2:2 TODO\n3-3\n4:4 TODO\n5-5\n",
+		n:       1,
+	},
+	// exactly nlines apart
+	{
+		str:     "1 TODO\n2\n3 TODO\n4\n",
+		nbefore: 2,
+		nafter:  2,
+		exp:     "1:1 TODO\n2-2\n3:3 TODO\n4-4\n",
+		n:       1,
+	},
+	// adjacent windows
+	{
+		str:     "1 TODO\n2\n3\n4\n5\n6 TODO\n",
+		nbefore: 2,
+		nafter:  2,
+		exp:     "1:1 TODO\n2-2\n3-3\n4-4\n5-5\n6:6 TODO\n",
+		n:       1,
+	},
+	// separated windows
+	{
+		str:     "1 TODO\n2\n3\n4\n5\n6\n7 TODO\n",
+		nbefore: 2,
+		nafter:  2,
+		exp:     "1:1 TODO\n2-2\n3-3\n5-5\n6-6\n7:7 TODO\n",
+		n:       2,
+	},
+	// three matches
+	{
+		str:    "1 TODO\n2 TODO\n3\n4 TODO\n",
+		nafter: 1,
+		exp:    "1:1 TODO\n2:2 TODO\n3-3\n4:4 TODO\n",
+		n:      1,
+	},
+}
+
+func TestMergeContexts(t *testing.T) {
+	for _, test := range mergeContextsTests {
+		fr := NewFileReader(regexp.MustCompile("TODO"), test.nbefore, test.nafter)
+		fr.merge = true
+		f := readString(t, fr, test.str)
+		var out string
+		for _, c := range f.Contexts {
+			out += c.String()
+		}
+		if out != test.exp || len(f.Contexts) != test.n {
+			t.Errorf("str=%q: contexts=%d\nout=%q\nexp=%q", test.str, len(f.Contexts), out, test.exp)
+		}
+	}
+}
+
+func TestMergeContextsMatches(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	fr.merge = true
+	f := readString(t, fr, "a TODO\nb\nc TODO\n")
+	if len(f.Contexts) != 1 {
+		t.Fatalf("contexts=%d, exp 1", len(f.Contexts))
+	}
+	var nums []uint
+	for _, m := range f.Contexts[0].matches() {
+		nums = append(nums, m.lines[m.index].Num)
+	}
+	if len(nums) != 2 || nums[0] != 1 || nums[1] != 3 {
+		t.Errorf("matched lines=%v, exp [1 3]", nums)
+	}
+}
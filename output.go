@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type jsonFile struct {
+	Path      string         `json:"path"`
+	Contexts  []*jsonContext `json:"contexts"`
+	Truncated bool           `json:"truncated,omitempty"`
+}
+
+type jsonContext struct {
+	Line     *Line   `json:"line"`
+	Before   []*Line `json:"before"`
+	After    []*Line `json:"after"`
+	Patterns []int   `json:"patterns"`
+
+	Annotation *Annotation `json:"annotation,omitempty"`
+	Enclosing  *Line       `json:"enclosing,omitempty"`
+	Blame      *Blame      `json:"blame,omitempty"`
+}
+
+func newJSONFile(f *File) *jsonFile {
+	jf := &jsonFile{
+		Path:      f.Path,
+		Contexts:  make([]*jsonContext, len(f.Contexts)),
+		Truncated: f.truncated,
+	}
+	for i, c := range f.Contexts {
+		jf.Contexts[i] = &jsonContext{
+			Line:      c.Line(),
+			Before:    append([]*Line{}, c.Before()...),
+			After:     append([]*Line{}, c.After()...),
+			Patterns:  append([]int{}, c.patterns...),
+			Enclosing: c.enclosing,
+			Blame:     c.blame,
+		}
+		if a := c.Annotation(); a != (Annotation{}) {
+			jf.Contexts[i].Annotation = &a
+		}
+	}
+	return jf
+}
+
+// FprintFilesJSON writes fs as a JSON array terminated by newline.
+func FprintFilesJSON(writer io.Writer, fs ...*File) error {
+	jfs := make([]*jsonFile, len(fs))
+	for i, f := range fs {
+		jfs[i] = newJSONFile(f)
+	}
+	return json.NewEncoder(writer).Encode(jfs)
+}
+
+// FprintFilesCSV writes matched lines of fs as CSV with header
+// "path,line,text", contexts are omitted.
+func FprintFilesCSV(writer io.Writer, fs ...*File) error {
+	cw := csv.NewWriter(writer)
+	if err := cw.Write([]string{"path", "line", "text"}); err != nil {
+		return err
+	}
+	for _, f := range fs {
+		for _, m := range f.Matches() {
+			if err := cw.Write([]string{m.Path, strconv.FormatUint(uint64(m.Line), 10), m.Text}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// FprintFilesMarkdown writes matched lines of fs as task lists under
+// headings of paths, matched substrings are wrapped by backticks.
+// other lines of contexts are written as quotes if withContext.
+func FprintFilesMarkdown(writer io.Writer, withContext bool, fs ...*File) error {
+	for _, f := range fs {
+		if len(f.Contexts) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(writer, "### %s\n\n", mdEscape(f.Path)); err != nil {
+			return err
+		}
+		for _, c := range f.Contexts {
+			for _, m := range c.matches() {
+				l := m.lines[m.index]
+				_, err := fmt.Fprintf(writer, "- [ ] line %d: %s\n", l.Num, mdMatchedText(l.Str, m.loc))
+				if err != nil {
+					return err
+				}
+			}
+			if !withContext {
+				continue
+			}
+			for i, l := range c.lines {
+				if c.matchAt(i) != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(writer, "  > %d: %s\n", l.Num, mdEscape(l.Str)); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := fmt.Fprintln(writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mdMatchedText returns escaped s that loc of s is a code span.
+func mdMatchedText(s string, loc []int) string {
+	if len(loc) != 2 || loc[0] == loc[1] {
+		return mdEscape(s)
+	}
+	return mdEscape(s[:loc[0]]) + mdCode(s[loc[0]:loc[1]]) + mdEscape(s[loc[1]:])
+}
+
+var mdReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`,
+	"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`,
+)
+
+// mdEscape escapes characters that have meaning in Markdown.
+func mdEscape(s string) string {
+	return mdReplacer.Replace(s)
+}
+
+// mdCode returns s as a code span, the fence is longer than backticks in s.
+func mdCode(s string) string {
+	var n, max int
+	for _, r := range s {
+		if r != '`' {
+			n = 0
+			continue
+		}
+		if n++; n > max {
+			max = n
+		}
+	}
+	fence := strings.Repeat("`", max+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// FprintColumns writes matched lines of fs as "path:line:column:text".
+func FprintColumns(writer io.Writer, fs ...*File) error {
+	for _, f := range fs {
+		for _, c := range f.Contexts {
+			for _, m := range c.matches() {
+				l := m.lines[m.index]
+				_, err := fmt.Fprintf(writer, "%s:%d:%d:%s\n", f.Path, l.Num, m.Column(), m.matchedText())
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// FprintGroupedByTag writes matched lines of fs as "path:line:text" under
+// headers of tags, tags[i] is the name of i-th pattern. a line matched
+// several patterns is written under each tags. if there is only one pattern,
+// lines are grouped by the matched text instead, e.g. "TODO" and "FIXME" of
+// "TODO|FIXME", in order of appearance.
+func FprintGroupedByTag(writer io.Writer, tags []string, fs ...*File) error {
+	if len(tags) <= 1 {
+		return fprintGroupedByText(writer, tags, fs)
+	}
+	groups := make([][]string, len(tags))
+	for _, f := range fs {
+		for _, c := range f.Contexts {
+			for _, m := range c.matches() {
+				l := m.lines[m.index]
+				for _, i := range m.patterns {
+					if i < len(groups) {
+						groups[i] = append(groups[i], fmt.Sprintf("%s:%d:%s\n", f.Path, l.Num, m.matchedText()))
+					}
+				}
+			}
+		}
+	}
+	return fprintGroups(writer, tags, groups)
+}
+
+// fprintGroupedByText is FprintGroupedByTag for one pattern, lines that the
+// matched text is unknown e.g. inverted are grouped under tags[0].
+func fprintGroupedByText(writer io.Writer, tags []string, fs []*File) error {
+	var keys []string
+	var groups [][]string
+	index := make(map[string]int)
+	for _, f := range fs {
+		for _, c := range f.Contexts {
+			for _, m := range c.matches() {
+				l := m.lines[m.index]
+				key := m.matchedSubstring()
+				if key == "" && len(tags) != 0 {
+					key = tags[0]
+				}
+				i, ok := index[key]
+				if !ok {
+					i = len(keys)
+					index[key] = i
+					keys = append(keys, key)
+					groups = append(groups, nil)
+				}
+				groups[i] = append(groups[i], fmt.Sprintf("%s:%d:%s\n", f.Path, l.Num, m.matchedText()))
+			}
+		}
+	}
+	return fprintGroups(writer, keys, groups)
+}
+
+// fprintGroups writes groups[i] under the header of keys[i], separated by
+// empty lines. empty groups are not written.
+func fprintGroups(writer io.Writer, keys []string, groups [][]string) error {
+	first := true
+	for i, lines := range groups {
+		if len(lines) == 0 {
+			continue
+		}
+		sep := "\n"
+		if first {
+			sep = ""
+			first = false
+		}
+		if _, err := fmt.Fprintf(writer, "%s[%s]\n", sep, keys[i]); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := io.WriteString(writer, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FprintTagSummary writes counts as "TAG: N, TAG: N" terminated by newline,
+// sorted by counts in descending order then tags.
+func FprintTagSummary(writer io.Writer, counts map[string]int) error {
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if counts[tags[i]] != counts[tags[j]] {
+			return counts[tags[i]] > counts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	items := make([]string, len(tags))
+	for i, tag := range tags {
+		items[i] = fmt.Sprintf("%s: %d", tag, counts[tag])
+	}
+	_, err := fmt.Fprintln(writer, strings.Join(items, ", "))
+	return err
+}
+
+// FprintSummary writes a footer of the scan as
+// "scanned 412 files, 37 matches in 12 files (1.3s)" terminated by newline.
+func FprintSummary(writer io.Writer, stats Stats, elapsed time.Duration) error {
+	_, err := fmt.Fprintf(writer, "scanned %s, %s in %s (%s)\n",
+		plural(stats.FilesScanned, "file"), plural(stats.LinesMatched, "match"),
+		plural(stats.FilesMatched, "file"), roundElapsed(elapsed))
+	return err
+}
+
+// plural returns "1 file" or "2 files".
+func plural(n int64, noun string) string {
+	switch {
+	case n == 1:
+		return "1 " + noun
+	case strings.HasSuffix(noun, "ch"):
+		return fmt.Sprintf("%d %ses", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// roundElapsed rounds d to 0.1s, or to 1ms if shorter than a second.
+func roundElapsed(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(100 * time.Millisecond)
+}
+
+// FprintCounts writes number of matched lines for each fs.
+// files that has no matched line are written only if includeZero.
+func FprintCounts(writer io.Writer, includeZero bool, fs ...*File) error {
+	for _, f := range fs {
+		if f.Count() == 0 && !includeZero {
+			continue
+		}
+		if err := f.FprintCount(writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FprintFileNames writes paths of fs that have matched lines, one per line.
+func FprintFileNames(writer io.Writer, fs ...*File) error {
+	for _, f := range fs {
+		if len(f.Contexts) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintln(writer, f.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FprintCandidates writes paths of fs that are candidates of dry run, one
+// per line.
+func FprintCandidates(writer io.Writer, fs ...*File) error {
+	for _, f := range fs {
+		if !f.candidate {
+			continue
+		}
+		if _, err := fmt.Fprintln(writer, f.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FprintFilesWithoutMatch writes paths of scanned that are not paths of
+// matched files, one per line. scanned is e.g. Walker.Scanned.
+func FprintFilesWithoutMatch(writer io.Writer, scanned []string, matched []*File) error {
+	found := make(map[string]bool, len(matched))
+	for _, f := range matched {
+		if len(f.Contexts) != 0 {
+			found[f.Path] = true
+		}
+	}
+	for _, path := range scanned {
+		if found[path] {
+			continue
+		}
+		if _, err := fmt.Fprintln(writer, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FprintFilesZero writes paths of fs that have matched lines, each path is
+// terminated by NUL for "xargs -0". duplicated paths are written once.
+func FprintFilesZero(writer io.Writer, fs ...*File) error {
+	written := make(map[string]bool)
+	for _, f := range fs {
+		if len(f.Contexts) == 0 || written[f.Path] {
+			continue
+		}
+		written[f.Path] = true
+		if _, err := io.WriteString(writer, f.Path+"\x00"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultOutputTemplate is the template of a line for FprintTemplate, it is
+// the same format as Context.String.
+const DefaultOutputTemplate = `{{.Num}}{{if .Matched}}:{{else}}-{{end}}{{.Str}}`
+
+// TemplateLine is the data of a line for FprintTemplate.
+type TemplateLine struct {
+	Path    string
+	Num     uint
+	Str     string
+	Matched bool // false for lines of before and after
+}
+
+// FprintTemplate writes lines of contexts of fs by tmpl, each line is
+// terminated by newline.
+func FprintTemplate(writer io.Writer, tmpl *template.Template, fs ...*File) error {
+	for _, f := range fs {
+		for _, c := range f.Contexts {
+			for i, l := range c.lines {
+				tl := TemplateLine{Path: f.Path, Num: l.Num, Str: c.text(l)}
+				if m := c.matchAt(i); m != nil {
+					tl.Str = m.matchedText()
+					tl.Matched = true
+				}
+				if err := tmpl.Execute(writer, tl); err != nil {
+					return err
+				}
+				if _, err := io.WriteString(writer, "\n"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFprintFilesJSON(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	f := readString(t, fr, "a\nTODO: b\nc\nd\n")
+	f.Path = "a.txt"
+
+	buf := new(bytes.Buffer)
+	if err := FprintFilesJSON(buf, f); err != nil {
+		t.Fatal(err)
+	}
+	exp := `[{"path":"a.txt","contexts":[{"line":{"num":2,"str":"TODO: b"},` +
+		`"before":[{"num":1,"str":"a"}],"after":[{"num":3,"str":"c"}],"patterns":[0]}]}]` + "\n"
+	if out := buf.String(); out != exp {
+		t.Errorf("\nout=%s\nexp=%s", out, exp)
+	}
+
+	var out []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out[0]["path"], "a.txt") {
+		t.Errorf("path=%v", out[0]["path"])
+	}
+
+	buf.Reset()
+	if err := FprintFilesJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); out != "[]\n" {
+		t.Errorf("empty: out=%q", out)
+	}
+}
+
+func TestFprintColumns(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	f := readString(t, fr, "TODO: a\nnone\n// あい TODO: b\n")
+	f.Path = "a.txt"
+
+	if out, exp := f.Contexts[1].Offset(), len("// あい "); out != exp {
+		t.Errorf("Offset()=%d, exp %d", out, exp)
+	}
+	buf := new(bytes.Buffer)
+	if err := FprintColumns(buf, f); err != nil {
+		t.Fatal(err)
+	}
+	exp := "a.txt:1:1:TODO: a\n" + "a.txt:3:7:// あい TODO: b\n"
+	if out := buf.String(); out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+}
+
+func TestFprintCounts(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	fr.countOnly = true
+	a := readString(t, fr, "TODO\nTODO TODO\nnone\nTODO\n")
+	a.Path = "a.txt"
+	if len(a.Contexts) != 0 {
+		t.Errorf("contexts are built in count only mode: %d", len(a.Contexts))
+	}
+	b := readString(t, fr, "none\n")
+	b.Path = "b.txt"
+
+	tests := []struct {
+		includeZero bool
+		exp         string
+	}{
+		{includeZero: false, exp: "a.txt: 3\n"},
+		{includeZero: true, exp: "a.txt: 3\nb.txt: 0\n"},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := FprintCounts(buf, test.includeZero, a, b); err != nil {
+			t.Fatal(err)
+		}
+		if out := buf.String(); out != test.exp {
+			t.Errorf("includeZero=%v: out=%q, exp=%q", test.includeZero, out, test.exp)
+		}
+	}
+}
+
+func TestFprintFilesJSONAnnotation(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	fr.annotation = regexp.MustCompile(`TODO\((?P<author>\w+)\)`)
+	f := readString(t, fr, "TODO(alice): a\nTODO: b\n")
+	f.Path = "a.txt"
+
+	buf := new(bytes.Buffer)
+	if err := FprintFilesJSON(buf, f); err != nil {
+		t.Fatal(err)
+	}
+	exp := `[{"path":"a.txt","contexts":[` +
+		`{"line":{"num":1,"str":"TODO(alice): a"},"before":[],"after":[],"patterns":[0],"annotation":{"author":"alice"}},` +
+		`{"line":{"num":2,"str":"TODO: b"},"before":[],"after":[],"patterns":[0]}]}]` + "\n"
+	if out := buf.String(); out != exp {
+		t.Errorf("\nout=%s\nexp=%s", out, exp)
+	}
+}
+
+func TestFprintGroupedByTag(t *testing.T) {
+	res := []*regexp.Regexp{
+		regexp.MustCompile("TODO"),
+		regexp.MustCompile("FIXME"),
+		regexp.MustCompile("HACK"),
+		regexp.MustCompile("XXX"),
+	}
+	fr := NewMultiFileReader(res, 0, 0)
+	a := readString(t, fr, "TODO: a\nFIXME: b\nnone\nHACK TODO: c\n")
+	a.Path = "a.txt"
+	b := readString(t, fr, "FIXME: d\n")
+	b.Path = "b.txt"
+
+	buf := new(bytes.Buffer)
+	if err := FprintGroupedByTag(buf, []string{"TODO", "FIXME", "HACK", "XXX"}, a, b); err != nil {
+		t.Fatal(err)
+	}
+	exp := "[TODO]\n" + "a.txt:1:TODO: a\n" + "a.txt:4:HACK TODO: c\n" +
+		"\n[FIXME]\n" + "a.txt:2:FIXME: b\n" + "b.txt:1:FIXME: d\n" +
+		"\n[HACK]\n" + "a.txt:4:HACK TODO: c\n"
+	if out := buf.String(); out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+
+	// one pattern is grouped by matched text
+	fr = NewFileReader(regexp.MustCompile("TODO|FIXME|HACK"), 0, 0)
+	a = readString(t, fr, "TODO: a\nFIXME: b\nnone\nHACK TODO: c\n")
+	a.Path = "a.txt"
+	b = readString(t, fr, "FIXME: d\n")
+	b.Path = "b.txt"
+	buf.Reset()
+	if err := FprintGroupedByTag(buf, []string{"TODO|FIXME|HACK"}, a, b); err != nil {
+		t.Fatal(err)
+	}
+	exp = "[TODO]\n" + "a.txt:1:TODO: a\n" +
+		"\n[FIXME]\n" + "a.txt:2:FIXME: b\n" + "b.txt:1:FIXME: d\n" +
+		"\n[HACK]\n" + "a.txt:4:HACK TODO: c\n"
+	if out := buf.String(); out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+}
+
+func TestFprintFilesCSV(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	fr.merge = true
+	a := readString(t, fr, "TODO: a, \"b\"\nnone\nTODO: c\n")
+	a.Path = "a,1.txt"
+	b := readString(t, fr, "none\n")
+	b.Path = "b.txt"
+
+	buf := new(bytes.Buffer)
+	if err := FprintFilesCSV(buf, a, b); err != nil {
+		t.Fatal(err)
+	}
+	out, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := [][]string{
+		{"path", "line", "text"},
+		{"a,1.txt", "1", `TODO: a, "b"`},
+		{"a,1.txt", "3", "TODO: c"},
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestFprintFilesMarkdown(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO`?"), 1, 1)
+	f := readString(t, fr, "before\n// TODO: *bold* and `code`\nafter_x\n\nTODO` x\n")
+	f.Path = "a_b.txt"
+
+	tests := []struct {
+		withContext bool
+		exp         string
+	}{
+		{
+			withContext: false,
+			exp: "### a\\_b.txt\n\n" +
+				"- [ ] line 2: // `TODO`: \\*bold\\* and \\`code\\`\n" +
+				"- [ ] line 5: `` TODO` `` x\n\n",
+		},
+		{
+			withContext: true,
+			exp: "### a\\_b.txt\n\n" +
+				"- [ ] line 2: // `TODO`: \\*bold\\* and \\`code\\`\n" +
+				"  > 1: before\n" +
+				"  > 3: after\\_x\n" +
+				"- [ ] line 5: `` TODO` `` x\n" +
+				"  > 4: \n\n",
+		},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := FprintFilesMarkdown(buf, test.withContext, f); err != nil {
+			t.Fatal(err)
+		}
+		if out := buf.String(); out != test.exp {
+			t.Errorf("withContext=%v:\nout=%q\nexp=%q", test.withContext, out, test.exp)
+		}
+	}
+}
+
+func TestFprintFileNames(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 1)
+	fr.namesOnly = true
+	a := readString(t, fr, "a\nTODO: a\nTODO: b\n")
+	a.Path = "a.txt"
+	b := readString(t, fr, "none\n")
+	b.Path = "b.txt"
+	if len(a.Contexts) != 1 || a.Contexts[0].String() != "2:TODO: a\n" {
+		t.Errorf("names only: contexts=%q", a.Contexts)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := FprintFileNames(buf, a, b); err != nil {
+		t.Fatal(err)
+	}
+	if out, exp := buf.String(), "a.txt\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestFprintFilesZero(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 0, 0)
+	var fs []*File
+	for _, path := range []string{"a.txt", "dir/b c.txt", "a.txt"} {
+		f := readString(t, fr, "TODO\n")
+		f.Path = path
+		fs = append(fs, f)
+	}
+	none := readString(t, fr, "none\n")
+	none.Path = "none.txt"
+	fs = append(fs, none)
+
+	buf := new(bytes.Buffer)
+	if err := FprintFilesZero(buf, fs...); err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Split(strings.TrimSuffix(buf.String(), "\x00"), "\x00")
+	if exp := []string{"a.txt", "dir/b c.txt"}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestFprintTemplate(t *testing.T) {
+	fr := NewFileReader(regexp.MustCompile("TODO"), 1, 0)
+	f := readString(t, fr, "a\nTODO: b\n")
+	f.Path = "a.txt"
+
+	w := NewWalker()
+	if err := w.SetOutputTemplate("{{.Path"); err == nil {
+		t.Error("expected error for invalid template")
+	}
+	buf := new(bytes.Buffer)
+	if err := FprintTemplate(buf, w.OutputTemplate(), f); err != nil {
+		t.Fatal(err)
+	}
+	if out, exp := buf.String(), f.Contexts[0].String(); out != exp {
+		t.Errorf("default: out=%q, exp=%q", out, exp)
+	}
+
+	if err := w.SetOutputTemplate(`{{.Path}}{{"\t"}}{{.Num}}{{"\t"}}{{if .Matched}}*{{end}}{{.Str}}`); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := FprintTemplate(buf, w.OutputTemplate(), f); err != nil {
+		t.Fatal(err)
+	}
+	if out, exp := buf.String(), "a.txt\t1\ta\na.txt\t2\t*TODO: b\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestFprintTagSummary(t *testing.T) {
+	buf := new(bytes.Buffer)
+	counts := map[string]int{"HACK": 9, "TODO": 142, "XXX": 9, "FIXME": 37}
+	if err := FprintTagSummary(buf, counts); err != nil {
+		t.Fatal(err)
+	}
+	if out, exp := buf.String(), "TODO: 142, FIXME: 37, HACK: 9, XXX: 9\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestFprintSummary(t *testing.T) {
+	tests := []struct {
+		stats   Stats
+		elapsed time.Duration
+		exp     string
+	}{
+		{
+			stats:   Stats{FilesScanned: 412, FilesMatched: 12, LinesMatched: 37},
+			elapsed: 1312 * time.Millisecond,
+			exp:     "scanned 412 files, 37 matches in 12 files (1.3s)\n",
+		},
+		{
+			stats:   Stats{FilesScanned: 1, FilesMatched: 1, LinesMatched: 1},
+			elapsed: 2345 * time.Microsecond,
+			exp:     "scanned 1 file, 1 match in 1 file (2ms)\n",
+		},
+		{
+			exp: "scanned 0 files, 0 matches in 0 files (0s)\n",
+		},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := FprintSummary(buf, test.stats, test.elapsed); err != nil {
+			t.Fatal(err)
+		}
+		if out := buf.String(); out != test.exp {
+			t.Errorf("out=%q, exp=%q", out, test.exp)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//go:build regexp2
+
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+)
+
+func init() {
+	regexpEngines[EngineRegexp2] = func(pat string, opts engineOptions) (IndexMatcher, error) {
+		re, err := regexp2.Compile(pat, regexp2.RE2)
+		if err != nil {
+			return nil, err
+		}
+		if opts.wholeWord {
+			// pat is compiled alone at first, then it is wrapped as a whole
+			re, err = regexp2.Compile(`(?<![\p{L}\p{N}_])(?:`+pat+`)(?![\p{L}\p{N}_])`, regexp2.RE2)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if opts.timeout > 0 {
+			re.MatchTimeout = opts.timeout
+		}
+		return &Regexp2Matcher{re: re}, nil
+	}
+}
+
+// Regexp2Matcher matches lines by regexp2.
+type Regexp2Matcher struct {
+	re *regexp2.Regexp
+}
+
+func (m *Regexp2Matcher) Match(line string) bool {
+	ok, err := m.re.MatchString(line)
+	return ok && err == nil
+}
+
+func (m *Regexp2Matcher) FindAllIndex(line string, n int) [][]int {
+	var locs [][]int
+	// indexes of regexp2 are in runes.
+	offsets := runeOffsets(line)
+	match, err := m.re.FindStringMatch(line)
+	for ; match != nil && err == nil && (n < 0 || len(locs) < n); match, err = m.re.FindNextMatch(match) {
+		locs = append(locs, []int{offsets[match.Index], offsets[match.Index+match.Length]})
+	}
+	return locs
+}
+
+// runeOffsets returns byte offsets of each runes of s and len(s).
+func runeOffsets(s string) []int {
+	offsets := make([]int, 0, utf8.RuneCountInString(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
@@ -0,0 +1,98 @@
+//go:build regexp2
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegexp2Engine(t *testing.T) {
+	dir := tempDir(t)
+	writeFiles(t, dir, map[string]string{
+		"a.go": "// TODO: comment\nx := \"TODO\" // あTODO\n",
+	})
+	w := NewWalker()
+	if err := w.SetLiteral(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexpEngine(EngineRegexp2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp(`(?<!//\s)TODO`); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetHighlight(true, ">", "<"); err != nil {
+		t.Fatal(err)
+	}
+	fs := walk(t, w, dir)
+	exp := []string{"x := \">TODO<\" // あ>TODO<"}
+	var out []string
+	for _, f := range fs {
+		for _, c := range f.Contexts {
+			out = append(out, c.matchedText())
+		}
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestRegexp2Timeout(t *testing.T) {
+	dir := tempDir(t)
+	writeFiles(t, dir, map[string]string{
+		"slow.txt": strings.Repeat("a", 64) + "\n",
+		"fast.txt": "ab\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexpEngine(EngineRegexp2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp(`^(a|aa)+$(?<=b)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetFileTimeout(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	if err := w.SetErrorHandler(func(err error) { errs = append(errs, err) }); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	walk(t, w, dir)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("match timeout is not applied: %v", d)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrTimeout) {
+		t.Errorf("errs=%v", errs)
+	}
+}
+
+func TestRegexp2WholeWord(t *testing.T) {
+	dir := tempDir(t)
+	writeFiles(t, dir, map[string]string{
+		"a.txt": "// TODO: x\nTODOLIST\nあTODO\nTODOS\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexpEngine(EngineRegexp2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRegexp("TODO|TODOS"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWholeWord(true); err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, f := range walk(t, w, dir) {
+		for _, m := range f.Matches() {
+			out = append(out, m.Text)
+		}
+	}
+	if exp := []string{"// TODO: x", "TODOS"}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Stats is counters of a scan.
+type Stats struct {
+	DirsScanned  int64
+	FilesScanned int64
+	FilesMatched int64
+	FilesSkipped int64
+	BytesScanned int64
+	LinesMatched int64
+
+	// reasons of skipped files.
+	SkippedPermission  int64
+	SkippedNotExist    int64
+	SkippedTooLong     int64
+	SkippedInvalidText int64
+	SkippedTimeout     int64
+}
+
+// SkippedFile is a file that skipped in the scan, Reason is the cause
+// e.g. ErrUnavailableText, bufio.ErrTooLong, ErrTooLarge and errors of
+// os.IsPermission or os.IsNotExist.
+type SkippedFile struct {
+	Path   string
+	Reason error
+}
+
+func (s *Stats) addDir() { atomic.AddInt64(&s.DirsScanned, 1) }
+
+func (s *Stats) addBytes(n int64) { atomic.AddInt64(&s.BytesScanned, n) }
+
+func (s *Stats) addFile(f *File) {
+	atomic.AddInt64(&s.FilesScanned, 1)
+	if f.count != 0 {
+		atomic.AddInt64(&s.FilesMatched, 1)
+	}
+	atomic.AddInt64(&s.LinesMatched, int64(f.count))
+}
+
+func (s *Stats) addSkip(err error) {
+	atomic.AddInt64(&s.FilesSkipped, 1)
+	if e, ok := err.(*ExpectedError); ok {
+		err = e.err
+	}
+	switch {
+	case os.IsPermission(err):
+		atomic.AddInt64(&s.SkippedPermission, 1)
+	case os.IsNotExist(err):
+		atomic.AddInt64(&s.SkippedNotExist, 1)
+	case err == bufio.ErrTooLong:
+		atomic.AddInt64(&s.SkippedTooLong, 1)
+	case err == ErrUnavailableText:
+		atomic.AddInt64(&s.SkippedInvalidText, 1)
+	case err == ErrTimeout:
+		atomic.AddInt64(&s.SkippedTimeout, 1)
+	}
+}
+
+// load returns copy of s.
+func (s *Stats) load() Stats {
+	return Stats{
+		DirsScanned:        atomic.LoadInt64(&s.DirsScanned),
+		FilesScanned:       atomic.LoadInt64(&s.FilesScanned),
+		FilesMatched:       atomic.LoadInt64(&s.FilesMatched),
+		FilesSkipped:       atomic.LoadInt64(&s.FilesSkipped),
+		BytesScanned:       atomic.LoadInt64(&s.BytesScanned),
+		LinesMatched:       atomic.LoadInt64(&s.LinesMatched),
+		SkippedPermission:  atomic.LoadInt64(&s.SkippedPermission),
+		SkippedNotExist:    atomic.LoadInt64(&s.SkippedNotExist),
+		SkippedTooLong:     atomic.LoadInt64(&s.SkippedTooLong),
+		SkippedInvalidText: atomic.LoadInt64(&s.SkippedInvalidText),
+		SkippedTimeout:     atomic.LoadInt64(&s.SkippedTimeout),
+	}
+}
+
+// countReader counts bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
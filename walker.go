@@ -1,16 +1,38 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 )
 
 var ErrAlreadyStarted = errors.New("Walker: already started")
+var ErrTooLarge = errors.New("file too large")
+var ErrDeadlineExceeded = errors.New("Walker: scan deadline exceeded")
+var ErrPatternMatchesEverything = errors.New("Walker: pattern matches everything")
+
+// StdinPath is the path for reading from standard input.
+const StdinPath = "-"
+
+// path of File that read from standard input.
+const stdinName = "<stdin>"
 
 type Walker struct {
 	fileQueue chan string
@@ -19,132 +41,1622 @@ type Walker struct {
 	// store checked files path.
 	checked map[string]bool
 
+	// store hashes of read files content if dedupByContent.
+	dedupByContent bool
+	hashes         map[[sha256.Size]byte]bool
+
+	// base names of directories to skip.
+	excludeDirs map[string]bool
+
+	// allowed file extensions, empty is allow all.
+	extensions map[string]bool
+	// excluded suffixes of file names, e.g. ".min.js".
+	excludeExts []string
+	// skip files modified before it, zero is no filter.
+	modifiedSince time.Time
+
+	// filters for file paths, nil is not filtered.
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+
+	// glob patterns for file paths relative from roots, empty is allow all.
+	globs []string
+	roots []string
+
+	// read ".gz" files as gzip.
+	readGzip bool
+
+	// read members of ".zip", ".tar" and ".tar.gz" files.
+	readArchive bool
+
+	// load .gitignore on traversal.
+	gitignore bool
+
+	// resolve symbolic links, or skip them.
+	followSymlinks bool
+
+	// skip dot files and directories in traversal.
+	skipHidden bool
+
+	// read named pipes and devices too.
+	includeNonRegular bool
+
+	// max depth of traversal from the sent paths, negative is unlimited.
+	maxDepth int
+
+	// skip files larger than maxFileSize, 0 is no limit.
+	maxFileSize int64
+
+	// abandon reading a file after fileTimeout, 0 is no limit.
+	fileTimeout time.Duration
+
 	// for fileWalker.
-	re      *regexp.Regexp
-	nbefore int
-	nafter  int
+	pats       []string
+	engine     string
+	ignoreCase bool
+	literal    bool
+	wholeWord  bool
+	fullLine   bool
+	allowEmpty bool
+	invert     bool
+	hl         *highlight
+	trimIndent bool
+	tmpl       *template.Template
+	less       func(a, b *Context) bool
+	annotation *regexp.Regexp
+	header     *regexp.Regexp
+	ms         []Matcher
+	nbefore    int
+	nafter     int
+	merge      bool
+	countOnly  bool
+	namesOnly  bool
+	dryRun     bool
+	lineBase   uint
+	binary     string
+	decode     func(io.Reader) io.Reader
+	maxLine    int
+	splitCR    bool
+	multiline  bool
+	mlMaxBytes int64
+	gitBlame   bool
+
+	// match patterns with base names of files too.
+	matchPath bool
+
+	// called for each matched lines as read.
+	onMatch func(path string, line uint, text string)
+
+	// path of the cache file, empty is disabled.
+	cachePath string
+
+	mu sync.Mutex
+	wg sync.WaitGroup
+
+	// errorhandler is for dirWalker and fileWalker.
+	// unexpected errors are recorded for Err too.
+	errorHandler func(error)
+
+	logger Logger
+
+	// number of dirWalker and fileWalker, 0 is decided by NumCPU.
+	nworker int
+	// a dirWalker and a fileWalker for reproducible results.
+	ordered bool
+
+	// capacity of the result queue, 0 is default.
+	maxBuffered int
+
+	// max number of files opened at the same time, 0 is number of workers.
+	maxOpenFiles int
+
+	// abort the scan after deadline from Start, 0 is no deadline.
+	deadline time.Duration
+
+	// capacities of the file and directory queues, 0 is default.
+	nfileQueue int
+	ndirQueue  int
+
+	// stop the scan after maxMatches matched lines, 0 is no limit.
+	maxMatches int
+	nmatches   int64
+
+	// stop reading a file after maxFileMatches matched lines, 0 is no limit.
+	maxFileMatches int
+
+	// search only first headLines lines and last tailLines lines of files,
+	// 0 is no limit.
+	headLines int
+	tailLines int
+
+	// stop the scan, it is called if reached max matches.
+	cancel context.CancelFunc
+
+	stats Stats
+
+	// time of the scan, from Start to the end of wait.
+	startTime time.Time
+	elapsed   time.Duration
+
+	// called for read files at most once in progressInterval, calls are
+	// not overlapped. the fields other than progress are atomic.
+	progress         func(filesDone int, currentPath string)
+	progressInterval time.Duration
+	progressBusy     int32
+	progressLast     int64 // unix nano of the last call
+	filesDone        int64
+
+	// results of the last Collect.
+	collected []*File
+
+	// base directory for relative path of results, empty is absolute path.
+	base string
+
+	// read for StdinPath.
+	stdin io.Reader
+
+	// file system to scan, nil is the file system of OS.
+	fsys fs.FS
+
+	isStarted bool
+	exitcode  int
+
+	// error of the context after canceled, or the first unexpected error.
+	err error
+
+	// all errors in the scan if collectErrors.
+	collectErrors bool
+	errs          []error
+
+	// skipped files in the scan.
+	skipped []SkippedFile
+
+	// paths of read files in the scan, scannedMu is separated from mu for
+	// each files.
+	scannedMu sync.Mutex
+	scanned   []string
+
+	// number of matched lines for each patterns in the scan.
+	tagCounts []int64
+}
+
+func NewWalker() *Walker {
+	return &Walker{
+		checked:      make(map[string]bool),
+		hashes:       make(map[[sha256.Size]byte]bool),
+		errorHandler: DefaultErrorHandler,
+		logger:       discardLogger{},
+		maxDepth:     -1,
+		lineBase:     1,
+		stdin:        os.Stdin,
+
+		progressInterval: defaultProgressInterval,
+	}
+}
+
+// DefaultErrorHandler ignores errors.
+// unexpected errors are returned by Walker.Err after the scan.
+var DefaultErrorHandler = func(err error) {}
+
+// isExpected reports whether err is expected on scanning, e.g. permission
+// denied, otherwise err is unexpected.
+func isExpected(err error) bool {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return true
+	}
+	var e *ExpectedError
+	return errors.As(err, &e)
+}
+
+func (w *Walker) SetErrorHandler(f func(error)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.errorHandler = f
+	return nil
+}
+
+// CollectAllErrors records all errors in the scan for Errors, e.g. each
+// permission denied paths.
+func (w *Walker) CollectAllErrors(collect bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.collectErrors = collect
+	return nil
+}
+
+// Errors returns all errors of the last scan if CollectAllErrors, errors
+// have their paths. it should be called after wait.
+func (w *Walker) Errors() []error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]error(nil), w.errs...)
+}
+
+func (w *Walker) SetRegexp(pat string) error {
+	return w.SetRegexps(pat)
+}
+
+// SetRegexps sets independent patterns.
+// matched contexts are recorded indexes of matched patterns.
+func (w *Walker) SetRegexps(pats ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if len(pats) == 0 {
+		return errors.New("SetRegexps: patterns not specified")
+	}
+	ms, err := w.compile(pats)
+	if err != nil {
+		return err
+	}
+	w.pats = pats
+	w.ms = ms
+	return nil
+}
+
+func (w *Walker) SetMatcher(m Matcher) error {
+	return w.SetMatchers(m)
+}
+
+// SetMatchers sets independent matchers instead of regexps.
+// matching options for regexps are not applied to ms.
+func (w *Walker) SetMatchers(ms ...Matcher) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if len(ms) == 0 {
+		return errors.New("SetMatchers: matchers not specified")
+	}
+	w.pats = nil
+	w.ms = ms
+	return nil
+}
+
+// SetIgnoreCase matches regexp case-insensitively.
+func (w *Walker) SetIgnoreCase(ignore bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	old := w.ignoreCase
+	w.ignoreCase = ignore
+	if err := w.recompile(); err != nil {
+		w.ignoreCase = old
+		return err
+	}
+	return nil
+}
+
+// SetLiteral treats patterns as fixed strings instead of regexps.
+func (w *Walker) SetLiteral(literal bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	old := w.literal
+	w.literal = literal
+	if err := w.recompile(); err != nil {
+		w.literal = old
+		return err
+	}
+	return nil
+}
+
+// SetWholeWord selects matches that form whole words.
+// word characters are Unicode letters, digits and underscore. a regexp is
+// wrapped as a whole by boundaries, e.g. "TODO|TODOS" matches "TODOS".
+func (w *Walker) SetWholeWord(on bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	old := w.wholeWord
+	w.wholeWord = on
+	if err := w.recompile(); err != nil {
+		w.wholeWord = old
+		return err
+	}
+	return nil
+}
+
+// SetFullLine selects lines that whole of the line matched patterns, e.g.
+// "TODO" matches "TODO" but not "// TODO: x".
+func (w *Walker) SetFullLine(on bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	old := w.fullLine
+	w.fullLine = on
+	if err := w.recompile(); err != nil {
+		w.fullLine = old
+		return err
+	}
+	return nil
+}
+
+// AllowEmptyMatch allows patterns that match the empty string, e.g. ".*"
+// or "TODO|". such patterns match every line, so that Start returns
+// ErrPatternMatchesEverything by default. patterns that match only empty
+// lines e.g. "^$", and patterns of SetFullLine are allowed regardless.
+func (w *Walker) AllowEmptyMatch(allow bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.allowEmpty = allow
+	return nil
+}
+
+// matchesEverything reports whether any of patterns matches both of the
+// empty string and a non-empty line, e.g. "^$" matches only empty lines and
+// it is not the case. inverted patterns and patterns of full lines are not
+// checked, and matchers of SetMatchers are not checked.
+func (w *Walker) matchesEverything() bool {
+	if w.allowEmpty || w.invert || w.fullLine || w.pats == nil {
+		return false
+	}
+	for _, m := range w.ms {
+		if m.Match("") && m.Match("\x00") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetInvertMatch selects non-matching lines.
+func (w *Walker) SetInvertMatch(invert bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.invert = invert
+	return nil
+}
+
+// SetHighlight wraps matched substrings with start and end on printing
+// contexts, e.g. "\x1b[31m" and "\x1b[0m".
+func (w *Walker) SetHighlight(on bool, start, end string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.hl = nil
+	if on {
+		w.hl = &highlight{start: start, end: end}
+	}
+	return nil
+}
+
+// SetTrimIndent prints lines without leading white spaces, e.g.
+// Context.String. stored lines and JSON output are not changed.
+func (w *Walker) SetTrimIndent(trim bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.trimIndent = trim
+	return nil
+}
+
+// SetOutputTemplate sets text/template for a line of FprintTemplate, the
+// data is TemplateLine, e.g. `{{.Path}}{{"\t"}}{{.Num}}{{"\t"}}{{.Str}}`.
+// empty is DefaultOutputTemplate.
+func (w *Walker) SetOutputTemplate(text string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if text == "" {
+		text = DefaultOutputTemplate
+	}
+	tmpl, err := template.New("line").Parse(text)
+	if err != nil {
+		return fmt.Errorf("SetOutputTemplate: %v", err)
+	}
+	w.tmpl = tmpl
+	return nil
+}
+
+// OutputTemplate returns the template that set by SetOutputTemplate.
+func (w *Walker) OutputTemplate() *template.Template {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tmpl == nil {
+		w.tmpl = template.Must(template.New("line").Parse(DefaultOutputTemplate))
+	}
+	return w.tmpl
+}
+
+// SetContextSort sorts contexts of each file by less, e.g. by
+// Context.Annotation. nil keeps order of lines as default.
+func (w *Walker) SetContextSort(less func(a, b *Context) bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.less = less
+	return nil
+}
+
+// SetShowEnclosing sets Context.Enclosing by the nearest line before the
+// matched line that matched header, e.g. "^func ". nil is disabled.
+// the line is printed before the context as "line=text".
+func (w *Walker) SetShowEnclosing(header *regexp.Regexp) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.header = header
+	return nil
+}
+
+// SetAnnotationPattern extracts Annotation from matched lines by re.
+// capture groups named "author" and "priority" are extracted, e.g.
+// `TODO\((?P<author>\w+)\)(?::\s*\[(?P<priority>P\d)\])?`. nil is disabled.
+func (w *Walker) SetAnnotationPattern(re *regexp.Regexp) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.annotation = re
+	return nil
+}
+
+// compile pats with matching options.
+func (w *Walker) compile(pats []string) ([]Matcher, error) {
+	ms := make([]Matcher, len(pats))
+	for i, pat := range pats {
+		var m IndexMatcher
+		if w.literal && !w.ignoreCase && !w.fullLine {
+			m = LiteralMatcher(pat)
+			if w.wholeWord {
+				m = &wordMatcher{m: m}
+			}
+		} else {
+			if w.literal {
+				pat = regexp.QuoteMeta(pat)
+			}
+			if w.fullLine {
+				pat = `\A(?:` + pat + `)\z`
+			}
+			if w.ignoreCase {
+				pat = "(?i)" + pat
+			}
+			engine := w.engine
+			if engine == "" {
+				engine = EngineRegexp
+			}
+			var err error
+			m, err = regexpEngines[engine](pat, engineOptions{wholeWord: w.wholeWord, timeout: w.fileTimeout})
+			if err != nil {
+				return nil, err
+			}
+		}
+		ms[i] = m
+	}
+	return ms, nil
+}
+
+// recompile patterns for changed matching options.
+func (w *Walker) recompile() error {
+	if len(w.pats) == 0 {
+		return nil
+	}
+	ms, err := w.compile(w.pats)
+	if err != nil {
+		return err
+	}
+	w.ms = ms
+	return nil
+}
+
+func (w *Walker) SetContext(nbefore, nafter int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.nbefore = nbefore
+	w.nafter = nafter
+	return nil
+}
+
+// SetMergeContext merges contexts that lines are overlapped or adjacent.
+func (w *Walker) SetMergeContext(merge bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.merge = merge
+	return nil
+}
+
+// SetCountOnly counts matched lines without building contexts.
+// the result is File.Count.
+func (w *Walker) SetCountOnly(countOnly bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.countOnly = countOnly
+	return nil
+}
+
+// SetNamesOnly stops reading a file at the first matched line, the result
+// has only a context of the line without before and after lines.
+// it is for listing names of matched files.
+func (w *Walker) SetNamesOnly(namesOnly bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.namesOnly = namesOnly
+	return nil
+}
+
+// SetDryRun lists files that pass filters without reading them, results
+// are files that have no contexts and are Candidate.
+func (w *Walker) SetDryRun(dryRun bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.dryRun = dryRun
+	return nil
+}
+
+// SetOnMatch sets fn that is called for each matched lines as files are
+// read, before contexts of the file are sent. fn is called concurrently
+// from workers, it must be safe for concurrent use. lines of files that are
+// skipped by errors later may be passed. nil disables it as default.
+func (w *Walker) SetOnMatch(fn func(path string, line uint, text string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.onMatch = fn
+	return nil
+}
+
+// SetMatchPath matches patterns with base names of files too, a matched
+// name is the first context of the file as line 0. it is not applied to
+// SetInvertMatch.
+func (w *Walker) SetMatchPath(match bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.matchPath = match
+	return nil
+}
+
+// SetLineBase sets number of the first line in results, 0 or 1.
+// default is 1.
+func (w *Walker) SetLineBase(base uint) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if base > 1 {
+		return errors.New("SetLineBase: base must be 0 or 1")
+	}
+	w.lineBase = base
+	return nil
+}
+
+// SetBinaryDetection sets mode of binary file detection.
+// mode is one of BinaryUTF8 (default), BinaryNulByte and BinaryOff.
+func (w *Walker) SetBinaryDetection(mode string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	switch mode {
+	case BinaryUTF8, BinaryNulByte, BinaryOff:
+	default:
+		return fmt.Errorf("SetBinaryDetection: unknown mode %q", mode)
+	}
+	w.binary = mode
+	return nil
+}
+
+// SetMaxLineSize sets max bytes of a line, longer lines than it are
+// skipped with bufio.ErrTooLong. 0 is bufio.MaxScanTokenSize (64KB).
+// each fileWalker may hold a buffer up to size bytes, so large size
+// costs memory as much as size * number of workers.
+func (w *Walker) SetMaxLineSize(size int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if size < 0 {
+		return errors.New("SetMaxLineSize: negative size")
+	}
+	w.maxLine = size
+	return nil
+}
+
+// SetSplitCR splits lines at bare "\r" of old Mac files too.
+// "\r\n" is always treated as a line ending.
+func (w *Walker) SetSplitCR(split bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.splitCR = split
+	return nil
+}
+
+// SetMultiline matches patterns over whole text of files, and selects lines
+// that overlapped matches. files are read into memory, limit the size by
+// SetMultilineMaxBytes or SetMaxFileSize if needed.
+func (w *Walker) SetMultiline(on bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.multiline = on
+	return nil
+}
+
+// SetMultilineMaxBytes matches files larger than n bytes line by line in
+// multiline mode instead of reading into memory, 0 is no limit as default.
+func (w *Walker) SetMultilineMaxBytes(n int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if n < 0 {
+		return errors.New("SetMultilineMaxBytes: negative size")
+	}
+	w.mlMaxBytes = n
+	return nil
+}
+
+// SetExcludeDirs skips sub directories that base name is one of names.
+// On windows names are compared case-insensitively.
+func (w *Walker) SetExcludeDirs(names ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.excludeDirs = make(map[string]bool, len(names))
+	for _, name := range names {
+		w.excludeDirs[dirKey(name)] = true
+	}
+	return nil
+}
+
+func dirKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func (w *Walker) isExcludedDir(name string) bool {
+	return len(w.excludeDirs) != 0 && w.excludeDirs[dirKey(name)]
+}
+
+// SetExtensions limits target files by extensions, e.g. "go" or ".go".
+// Empty exts is allow all files.
+func (w *Walker) SetExtensions(exts ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.extensions = make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		w.extensions["."+strings.TrimPrefix(ext, ".")] = true
+	}
+	return nil
+}
+
+// SetExcludeExtensions skips files that name ends with one of exts, e.g.
+// "min.js" or ".min.js". it wins over SetExtensions.
+func (w *Walker) SetExcludeExtensions(exts ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.excludeExts = make([]string, len(exts))
+	for i, ext := range exts {
+		w.excludeExts[i] = "." + strings.TrimPrefix(ext, ".")
+	}
+	return nil
+}
+
+// SetModifiedSince skips files modified before t, directories are still
+// traversed. zero t is no filter as default.
+func (w *Walker) SetModifiedSince(t time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.modifiedSince = t
+	return nil
+}
+
+func (w *Walker) isAllowedExt(path string) bool {
+	name := path
+	if w.readGzip && strings.HasSuffix(path, gzipExt) {
+		name = strings.TrimSuffix(path, gzipExt)
+	}
+	// filepath.Ext returns only the last one of multi-part extensions
+	for _, ext := range w.excludeExts {
+		if strings.HasSuffix(path, ext) || strings.HasSuffix(name, ext) {
+			return false
+		}
+	}
+	if len(w.extensions) == 0 {
+		return true
+	}
+	// members are checked on reading
+	if w.readArchive && archiveKind(path) != "" {
+		return true
+	}
+	return w.extensions[filepath.Ext(name)]
+}
+
+// SetPathFilter reads only files that path matched include and not matched
+// exclude. nil include allows all, nil exclude excludes nothing.
+func (w *Walker) SetPathFilter(include, exclude *regexp.Regexp) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.include = include
+	w.exclude = exclude
+	return nil
+}
+
+func (w *Walker) isAllowedPath(path string) bool {
+	if w.include != nil && !w.include.MatchString(path) {
+		return false
+	}
+	return w.exclude == nil || !w.exclude.MatchString(path)
+}
+
+// SetGlobs reads only files that matched any of patterns.
+// patterns are slash separated and matched to path relative from the sent
+// directory, "**" matches zero or more directories, e.g. "src/**/*.go".
+// patterns without slash are matched to base name of files, e.g. "*.go".
+func (w *Walker) SetGlobs(patterns ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	for _, pat := range patterns {
+		if _, err := path.Match(strings.Replace(pat, "**", "*", -1), ""); err != nil {
+			return err
+		}
+	}
+	w.globs = patterns
+	return nil
+}
+
+func (w *Walker) isAllowedGlob(file string) bool {
+	if len(w.globs) == 0 {
+		return true
+	}
+	rel := filepath.ToSlash(w.relFromRoot(file))
+	for _, pat := range w.globs {
+		if !strings.Contains(pat, "/") {
+			if ok, _ := path.Match(pat, path.Base(rel)); ok {
+				return true
+			}
+			continue
+		}
+		if matchGlob(strings.TrimPrefix(pat, "/"), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// relFromRoot returns path of file relative from the nearest sent directory,
+// or base name if file is not in the directories.
+func (w *Walker) relFromRoot(file string) string {
+	root := w.rootOf(file)
+	if root == file {
+		return filepath.Base(file)
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return filepath.Base(file)
+	}
+	return rel
+}
+
+// rootOf returns the nearest sent directory that contains file, or file
+// itself if file is not in the directories, e.g. a sent file.
+func (w *Walker) rootOf(file string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var root, rel string
+	for _, r := range w.roots {
+		rl, err := filepath.Rel(r, file)
+		if err != nil || isOutside(rl) {
+			continue
+		}
+		if root == "" || len(rl) < len(rel) {
+			root, rel = r, rl
+		}
+	}
+	if root == "" {
+		return file
+	}
+	return root
+}
+
+// SetReadGzip decompresses files that name ends with ".gz".
+func (w *Walker) SetReadGzip(read bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.readGzip = read
+	return nil
+}
+
+// EnableGitignore drops files and directories that matched .gitignore.
+func (w *Walker) EnableGitignore(enable bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.gitignore = enable
+	return nil
+}
+
+// SetFollowSymlinks follows symbolic links in traversal.
+// By default symbolic links are skipped.
+func (w *Walker) SetFollowSymlinks(follow bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.followSymlinks = follow
+	return nil
+}
+
+// SetIncludeNonRegular reads named pipes and devices too, default is only
+// regular files. a named pipe that has no writer is read as empty, reading
+// them may be blocked until written, SetFileTimeout is recommended.
+func (w *Walker) SetIncludeNonRegular(include bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.includeNonRegular = include
+	return nil
+}
+
+// isReadable reports whether files of mode should be read.
+func (w *Walker) isReadable(mode os.FileMode) bool {
+	if mode.IsRegular() {
+		return true
+	}
+	return w.includeNonRegular && mode&(os.ModeNamedPipe|os.ModeDevice|os.ModeCharDevice) != 0
+}
+
+// SetSkipHidden skips files and directories that name begins with ".".
+// the sent paths are not skipped even if hidden.
+func (w *Walker) SetSkipHidden(skip bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.skipHidden = skip
+	return nil
+}
+
+// SetDedupByContent skips files that content is same as already read file.
+func (w *Walker) SetDedupByContent(dedup bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.dedupByContent = dedup
+	return nil
+}
+
+// SetMaxDepth limits depth of traversal.
+// 0 is only the sent paths, 1 is their children, and so on.
+// negative is unlimited.
+func (w *Walker) SetMaxDepth(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.maxDepth = n
+	return nil
+}
+
+// SetMaxFileSize skips files larger than size bytes.
+// 0 is no limit.
+func (w *Walker) SetMaxFileSize(size int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if size < 0 {
+		return errors.New("SetMaxFileSize: negative size")
+	}
+	w.maxFileSize = size
+	return nil
+}
+
+// SetFileTimeout abandons reading a file after d, the file is skipped with
+// ErrTimeout and closed. deadline is checked for each reads and lines, and
+// blocked reads are limited if the file supports it. 0 is no limit.
+func (w *Walker) SetFileTimeout(d time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if d < 0 {
+		return errors.New("SetFileTimeout: negative duration")
+	}
+	old := w.fileTimeout
+	w.fileTimeout = d
+	// matches of backtracking engines are limited too
+	if err := w.recompile(); err != nil {
+		w.fileTimeout = old
+		return err
+	}
+	return nil
+}
+
+// SetMaxMatches stops the scan after n matched lines are sent in total.
+// the last file is truncated to n, 0 is no limit.
+func (w *Walker) SetMaxMatches(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if n < 0 {
+		return errors.New("SetMaxMatches: negative number")
+	}
+	w.maxMatches = n
+	return nil
+}
+
+// SetMaxMatchesPerFile stops reading a file after n matched lines and
+// their contexts. if count only, lines are counted to the end of the file.
+// 0 is no limit.
+func (w *Walker) SetMaxMatchesPerFile(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if n < 0 {
+		return errors.New("SetMaxMatchesPerFile: negative number")
+	}
+	w.maxFileMatches = n
+	return nil
+}
+
+// SetHeadLines searches only first n lines of each file, 0 is no limit.
+func (w *Walker) SetHeadLines(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if n < 0 {
+		return errors.New("SetHeadLines: negative number")
+	}
+	w.headLines = n
+	return nil
+}
+
+// SetTailLines searches only last n lines of each file, 0 is no limit.
+// all lines are still read. if with SetHeadLines, last n lines of the head.
+func (w *Walker) SetTailLines(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if n < 0 {
+		return errors.New("SetTailLines: negative number")
+	}
+	w.tailLines = n
+	return nil
+}
+
+// SetProgress sets fn that is called after files are read, filesDone is
+// number of read files in the scan. calls are throttled by
+// SetProgressInterval and not overlapped, a file read while fn is running
+// is not notified. nil is disabled.
+func (w *Walker) SetProgress(fn func(filesDone int, currentPath string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	w.progress = fn
+	return nil
+}
+
+// defaultProgressInterval is enough to render a spinner.
+const defaultProgressInterval = 100 * time.Millisecond
+
+// SetProgressInterval sets the minimum interval of calls of SetProgress,
+// default is defaultProgressInterval. 0 calls for each file.
+func (w *Walker) SetProgressInterval(d time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if d < 0 {
+		return errors.New("SetProgressInterval: negative duration")
+	}
+	w.progressInterval = d
+	return nil
+}
+
+func (w *Walker) notifyProgress(path string) {
+	if w.progress == nil {
+		return
+	}
+	atomic.AddInt64(&w.filesDone, 1)
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&w.progressLast) < int64(w.progressInterval) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&w.progressBusy, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&w.progressBusy, 0)
+	atomic.StoreInt64(&w.progressLast, now)
+	w.progress(int(atomic.LoadInt64(&w.filesDone)), w.displayPath(path))
+}
+
+// SetMaxBufferedFiles sets capacity of the result queue that returned by
+// Start, default is capacity of the file queue. workers are blocked while
+// the queue is full, then results must be received until the queue is
+// closed, or cancel the scan by StartContext.
+func (w *Walker) SetMaxBufferedFiles(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if n < 1 {
+		return errors.New("SetMaxBufferedFiles: n must be 1 or more")
+	}
+	w.maxBuffered = n
+	return nil
+}
 
-	mu sync.Mutex
-	wg sync.WaitGroup
+// SetDeadline aborts the scan after d from Start, 0 is no deadline.
+// results until the deadline are received, then Err returns
+// ErrDeadlineExceeded.
+func (w *Walker) SetDeadline(d time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if d < 0 {
+		return errors.New("SetDeadline: negative duration")
+	}
+	w.deadline = d
+	return nil
+}
 
-	// errorhandler is for dirWalker and fileWalker.
-	// if unexpected error coming then to panic is better.
-	errorHandler func(error)
+// defaultMaxOpenFiles is less than usual limits of file descriptors, e.g.
+// 256 of macOS and 1024 of Linux, the rest is for directories and others.
+const defaultMaxOpenFiles = 128
 
-	isStarted bool
-	exitcode  int
+// SetMaxOpenFiles limits number of files opened at the same time by
+// workers, default is defaultMaxOpenFiles. it does not reduce the workers.
+func (w *Walker) SetMaxOpenFiles(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if n < 1 {
+		return errors.New("SetMaxOpenFiles: n must be 1 or more")
+	}
+	w.maxOpenFiles = n
+	return nil
 }
 
-func NewWalker() *Walker {
-	return &Walker{
-		checked:      make(map[string]bool),
-		errorHandler: DefaultErrorHandler,
+// SetQueueSizes sets capacities of the file queue and the directory queue,
+// defaults are 128 and number of workers. the result queue has the same
+// capacity as the file queue unless SetMaxBufferedFiles.
+func (w *Walker) SetQueueSizes(fileCap, dirCap int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
+	}
+	if fileCap < 1 || dirCap < 1 {
+		return errors.New("SetQueueSizes: capacities must be 1 or more")
 	}
+	w.nfileQueue = fileCap
+	w.ndirQueue = dirCap
+	return nil
 }
 
-var DefaultErrorHandler = func(err error) {
-	if os.IsNotExist(err) || os.IsPermission(err) {
-		return
+// SetWorkers sets number of goroutines for each of directories and files.
+// n must be 1 or more.
+func (w *Walker) SetWorkers(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
 	}
-	if _, ok := err.(*ExpectedError); ok {
-		return
+	if n < 1 {
+		return errors.New("SetWorkers: number of workers must be 1 or more")
 	}
-	// unexpeted error
-	panic(err)
+	w.nworker = n
+	return nil
 }
 
-func (w *Walker) SetErrorHandler(f func(error)) error {
+// SetOrdered scans with a directory worker and a file worker, and results
+// are in order of breadth-first traversal, entries of each directory are
+// sorted by name. it is reproducible for SetMaxMatches and tests, but
+// slower than concurrent workers. SetWorkers is ignored if ordered.
+func (w *Walker) SetOrdered(ordered bool) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if w.isStarted {
 		return ErrAlreadyStarted
 	}
-	w.errorHandler = f
+	w.ordered = ordered
 	return nil
 }
 
-func (w *Walker) SetRegexp(pat string) error {
+// SetBasePath sets path of results relative from dir.
+// if the relative path is not available, the path is absolute.
+func (w *Walker) SetBasePath(dir string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if w.isStarted {
 		return ErrAlreadyStarted
 	}
-	re, err := regexp.Compile(pat)
-	if err != nil {
-		return err
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		dir = abs
 	}
-	w.re = re
+	w.base = dir
 	return nil
 }
 
-func (w *Walker) SetContext(nbefore, nafter int) error {
+// displayPath returns path for results.
+func (w *Walker) displayPath(path string) string {
+	if w.base == "" || path == stdinName {
+		return path
+	}
+	rel, err := filepath.Rel(w.base, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// SetStdin sets reader for StdinPath, default is os.Stdin.
+func (w *Walker) SetStdin(r io.Reader) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if w.isStarted {
 		return ErrAlreadyStarted
 	}
-	w.nbefore = nbefore
-	w.nafter = nafter
+	w.stdin = r
 	return nil
 }
 
+// SendPath sends paths to walkers.
+// StdinPath is read from standard input as a file "<stdin>".
 func (w *Walker) SendPath(paths ...string) error {
 	var dirs []string
 	for _, p := range paths {
-		abs, err := filepath.Abs(p)
+		if p == StdinPath {
+			w.wg.Add(1)
+			w.fileQueue <- StdinPath
+			continue
+		}
+		abs, err := w.abs(p)
 		if err != nil {
 			return err
 		}
-		fi, err := os.Stat(abs)
+		fi, err := w.stat(abs)
 		if err != nil {
 			return err
 		}
 		if fi.IsDir() {
 			dirs = append(dirs, abs)
-		} else if fi.Mode().IsRegular() {
+		} else if w.isReadable(fi.Mode()) {
 			w.wg.Add(1)
 			w.fileQueue <- abs
 		}
 	}
 	if len(dirs) != 0 {
+		w.mu.Lock()
+		w.roots = append(w.roots, dirs...)
+		w.mu.Unlock()
 		w.wg.Add(1)
 		w.dirQueue <- dirs
 	}
 	return nil
 }
 
-func (w *Walker) Start() (resultReceiver <-chan *File, wait func()) {
+// SendPathList sends files that listed in r, one path per line, e.g. output
+// of "git diff --name-only". blank lines and lines begin with "#" are
+// ignored. directories are not traversed and ignored.
+func (w *Walker) SendPathList(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		p := strings.TrimSpace(sc.Text())
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		abs, err := w.abs(p)
+		if err != nil {
+			return err
+		}
+		// not exist files are reported by workers
+		if fi, err := w.stat(abs); err == nil && !w.isReadable(fi.Mode()) {
+			continue
+		}
+		w.wg.Add(1)
+		w.fileQueue <- abs
+	}
+	return sc.Err()
+}
+
+// Start returns ErrPatternMatchesEverything without starting the scan if
+// patterns match the empty string, see AllowEmptyMatch.
+func (w *Walker) Start() (resultReceiver <-chan *File, wait func(), err error) {
+	return w.StartContext(context.Background())
+}
+
+// StartContext is like Start but the scan is aborted when ctx is done.
+// after aborted, resultReceiver is closed by wait and Err returns ctx.Err().
+// the deadline of SetDeadline is applied too.
+func (w *Walker) StartContext(parent context.Context) (resultReceiver <-chan *File, wait func(), err error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	nworker := runtime.NumCPU() / 4
-	if nworker < 2 {
-		nworker = 2
+	if w.matchesEverything() {
+		return nil, nil, ErrPatternMatchesEverything
+	}
+	// aborted by parent or the deadline
+	abort, stopDeadline := parent, context.CancelFunc(func() {})
+	if w.deadline > 0 {
+		abort, stopDeadline = context.WithTimeout(parent, w.deadline)
+	}
+	// canceled by abort, or reached max matches
+	ctx, cancel := context.WithCancel(abort)
+	w.cancel = cancel
+	nworker := w.nworker
+	if nworker == 0 {
+		nworker = runtime.NumCPU() / 4
+		if nworker < 2 {
+			nworker = 2
+		}
+	}
+	if w.ordered {
+		nworker = 1
 	}
 	nfileQueue := 128
+	if w.nfileQueue != 0 {
+		nfileQueue = w.nfileQueue
+	}
+	ndirQueue := nworker
+	if w.ndirQueue != 0 {
+		ndirQueue = w.ndirQueue
+	}
+	nresultQueue := nfileQueue
+	nopenFiles := defaultMaxOpenFiles
+	if w.maxOpenFiles != 0 {
+		nopenFiles = w.maxOpenFiles
+	}
+	if w.maxBuffered != 0 {
+		nresultQueue = w.maxBuffered
+	}
 
 	done := make(chan struct{})
-	rq := make(chan *File, nfileQueue)
+	rq := make(chan *File, nresultQueue)
 
 	errQueue := make(chan error, nfileQueue)
-	go w.handleError(errQueue, w.errorHandler)
+	errDone := make(chan struct{})
+	var unexpected error
+	go func() {
+		unexpected = w.handleError(errQueue, w.errorHandler)
+		close(errDone)
+	}()
 
-	w.dirQueue = make(chan []string, nworker)
-	w.fileQueue = make(chan string, nfileQueue)
+	// workers receive queues of this scan, the fields are renewed by the
+	// next scan.
+	dirQueue := make(chan []string, ndirQueue)
+	fileQueue := make(chan string, nfileQueue)
+	openFiles := make(chan struct{}, nopenFiles)
+	tagCounts := make([]int64, len(w.ms))
+	var cache *scanCache
+	// decoders are not comparable with the cached key
+	if w.cachePath != "" && w.pats != nil && w.decode == nil {
+		var err error
+		if cache, err = w.loadCache(); err != nil {
+			w.logger.Errorf("%v", err)
+			cache = &scanCache{key: w.cacheKey(), entries: make(map[string]*cacheEntry)}
+		}
+	}
+	w.dirQueue = dirQueue
+	w.fileQueue = fileQueue
+	w.tagCounts = tagCounts
+	// wait returns after workers exited, settings are not read after that.
+	var workers sync.WaitGroup
+	workers.Add(nworker * 2)
 	for i := 0; i != nworker; i++ {
-		go w.dirWalker(done, errQueue)
-		go w.fileWalker(done, rq, errQueue)
+		go func() {
+			defer workers.Done()
+			w.dirWalker(ctx, done, dirQueue, fileQueue, errQueue)
+		}()
+		go func() {
+			defer workers.Done()
+			w.fileWalker(ctx, abort.Done(), done, fileQueue, openFiles, tagCounts, cache, rq, errQueue)
+		}()
 	}
 
+	w.err = nil
+	w.errs = nil
+	w.skipped = nil
+	w.scanned = nil
+	w.nmatches = 0
+	w.roots = nil
+	w.stats = Stats{}
+	w.startTime = time.Now()
+	w.elapsed = 0
+	atomic.StoreInt64(&w.filesDone, 0)
+	atomic.StoreInt64(&w.progressLast, 0)
 	w.isStarted = true
 	return rq, func() {
 		w.wg.Wait()
 		close(errQueue)
+		<-errDone
+		cancel()
+		w.mu.Lock()
+		w.err = parent.Err()
+		if w.err == nil && abort.Err() == context.DeadlineExceeded {
+			w.err = ErrDeadlineExceeded
+		}
+		if w.err == nil {
+			w.err = unexpected
+		}
+		w.elapsed = time.Since(w.startTime)
+		w.mu.Unlock()
+		stopDeadline()
 		close(done)
-		close(rq)
+		workers.Wait()
+		var saveErr error
+		if cache != nil {
+			saveErr = cache.save(w.cachePath)
+		}
 		w.mu.Lock()
+		if saveErr != nil && w.err == nil {
+			w.err = saveErr
+		}
 		w.isStarted = false
 		w.mu.Unlock()
+		close(rq)
+	}, nil
+}
+
+// Collect scans paths by current settings and returns files that have
+// matched lines or candidates of SetDryRun, it is a shorthand of Start,
+// SendPath and wait.
+func (w *Walker) Collect(paths ...string) ([]*File, error) {
+	rq, wait, err := w.Start()
+	if err != nil {
+		return nil, err
+	}
+	// paths are sent while results are received, the queues are bounded.
+	var sendErr error
+	go func() {
+		sendErr = w.SendPath(paths...)
+		wait()
+	}()
+	var fs []*File
+	for f := range rq {
+		if f.Count() != 0 || f.candidate {
+			fs = append(fs, f)
+		}
+	}
+	w.mu.Lock()
+	w.collected = fs
+	w.mu.Unlock()
+	if sendErr != nil {
+		return fs, sendErr
+	}
+	return fs, w.Err()
+}
+
+// ForEach scans paths by current settings and calls fn for each file that
+// has matched lines as found. if fn returns an error, the scan is canceled
+// and ForEach returns the error after workers exited.
+func (w *Walker) ForEach(fn func(*File) error, paths ...string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rq, wait, err := w.StartContext(ctx)
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	go func() {
+		sendErr = w.SendPath(paths...)
+		wait()
+	}()
+	var fnErr error
+	for f := range rq {
+		if fnErr != nil || (f.Count() == 0 && !f.candidate) {
+			continue
+		}
+		if fnErr = fn(f); fnErr != nil {
+			cancel()
+		}
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	return w.Err()
+}
+
+// Search scans paths for regexp pat with nlines before and after lines by a
+// new Walker, and returns files that have matched lines sorted by path.
+func Search(pat string, nlines int, paths ...string) ([]*File, error) {
+	w := NewWalker()
+	if err := w.SetRegexp(pat); err != nil {
+		return nil, err
+	}
+	if err := w.SetContext(nlines, nlines); err != nil {
+		return nil, err
+	}
+	fs, err := w.Collect(paths...)
+	SortFiles(fs)
+	return fs, err
+}
+
+// ForEach scans paths for regexp pat with nlines before and after lines by a
+// new Walker, and calls fn for each file that has matched lines as found.
+// the scan is halted if fn returns an error, see Walker.ForEach.
+func ForEach(pat string, nlines int, fn func(*File) error, paths ...string) error {
+	w := NewWalker()
+	if err := w.SetRegexp(pat); err != nil {
+		return err
+	}
+	if err := w.SetContext(nlines, nlines); err != nil {
+		return err
+	}
+	return w.ForEach(fn, paths...)
+}
+
+// Matches returns matched lines of the last Collect.
+func (w *Walker) Matches() []Match {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ms []Match
+	for _, f := range w.collected {
+		ms = append(ms, f.Matches()...)
+	}
+	return ms
+}
+
+// Stats returns counters of the last scan.
+// it is safe to call on scanning.
+func (w *Walker) Stats() Stats {
+	return w.stats.load()
+}
+
+// HadMatches reports whether the last scan produced files that have
+// matched lines, it should be called after wait.
+func (w *Walker) HadMatches() bool {
+	return atomic.LoadInt64(&w.stats.LinesMatched) != 0
+}
+
+// Elapsed returns time of the last scan from Start to the end of wait, it
+// is 0 until wait returns.
+func (w *Walker) Elapsed() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.elapsed
+}
+
+// Err returns error of the context that given to StartContext if the scan
+// is canceled, otherwise the first unexpected error in the scan.
+// it is nil if the scan is completed without unexpected errors.
+func (w *Walker) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Reset forgets results of previous scans, e.g. checked files and the
+// error, then the next scan reads the same files again. settings are kept.
+// it must not be called on scanning.
+func (w *Walker) Reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.isStarted {
+		return ErrAlreadyStarted
 	}
+	w.checked = make(map[string]bool)
+	w.hashes = make(map[[sha256.Size]byte]bool)
+	w.collected = nil
+	w.err = nil
+	w.errs = nil
+	w.skipped = nil
+	w.scanned = nil
+	w.tagCounts = nil
+	w.exitcode = 0
+	w.nmatches = 0
+	w.stats = Stats{}
+	w.elapsed = 0
+	atomic.StoreInt64(&w.filesDone, 0)
+	return nil
 }
 
 func (w *Walker) WaitExitCode() int {
@@ -157,13 +1669,32 @@ func (w *Walker) WaitExitCode() int {
 	return w.exitcode
 }
 
-func (w *Walker) handleError(errQueue <-chan error, handler func(error)) {
+// handleError returns the first unexpected error.
+func (w *Walker) handleError(errQueue <-chan error, handler func(error)) (unexpected error) {
 	for err := range errQueue {
 		if err != nil {
-			w.exitcode = 1
+			if !isSkipped(err) {
+				w.exitcode = 1
+			}
+			if unexpected == nil && !isExpected(err) {
+				unexpected = err
+			}
+			if w.collectErrors {
+				w.mu.Lock()
+				w.errs = append(w.errs, err)
+				w.mu.Unlock()
+			}
+			w.logger.Errorf("%v", err)
 			handler(err)
 		}
 	}
+	return unexpected
+}
+
+// isSkipped reports whether err is just a skip by options.
+func isSkipped(err error) bool {
+	e, ok := err.(*ExpectedError)
+	return ok && (e.err == ErrTooLarge || e.err == ErrTimeout)
 }
 
 func (w *Walker) check(abs string) bool {
@@ -176,65 +1707,428 @@ func (w *Walker) check(abs string) bool {
 	return false
 }
 
-func (w *Walker) dirWalker(done <-chan struct{}, errQueue chan<- error) {
-	var dir string
+// checkPath is check for resolved path if following symbolic links.
+// return true if already checked.
+func (w *Walker) checkPath(path string) (bool, error) {
+	if w.followSymlinks {
+		real, err := w.evalSymlinks(path)
+		if err != nil {
+			return true, err
+		}
+		return w.check(real), nil
+	}
+	return w.check(path), nil
+}
+
+func (w *Walker) dirWalker(ctx context.Context, done <-chan struct{}, dirQueue <-chan []string, fileQueue chan<- string, errQueue chan<- error) {
+	var dir, path string
 	var dirs []string
 	var nextDirs []string
+	var ig *gitignore
+	var ignores []*gitignore
+	var nextIgnores []*gitignore
 	var fis []os.FileInfo
 	var err error
+	var depth int // depth of dirs from the sent paths
 	for ; ; w.wg.Done() {
 		select {
 		case <-done:
 			return
-		case dirs = <-w.dirQueue:
+		case dirs = <-dirQueue:
+			depth = 0
+			ignores = ignores[:0]
+			for range dirs {
+				ignores = append(ignores, nil)
+			}
 		NextDirs:
-			for _, dir = range dirs {
-				if w.check(dir) {
+			for i := range dirs {
+				if ctx.Err() != nil {
+					nextDirs = nextDirs[:0]
+					nextIgnores = nextIgnores[:0]
+					break
+				}
+				// children of dir are deeper than max depth
+				if w.maxDepth >= 0 && depth >= w.maxDepth {
+					continue
+				}
+				dir = dirs[i]
+				if checked, err := w.checkPath(dir); checked {
+					if err != nil {
+						errQueue <- err
+					}
 					continue
 				}
-				fis, err = ioutil.ReadDir(dir)
+				ig = ignores[i]
+				if w.gitignore {
+					ig, err = w.loadGitignore(ig, dir)
+					if err != nil {
+						errQueue <- err
+					}
+				}
+				fis, err = w.readDir(dir)
 				if err != nil {
 					errQueue <- err
 					continue
 				}
+				w.stats.addDir()
+				w.logger.Debugf("dir %s", dir)
 				for _, fi := range fis {
+					if w.skipHidden && strings.HasPrefix(fi.Name(), ".") {
+						continue
+					}
+					path = w.join(dir, fi.Name())
+					if fi.Mode()&os.ModeSymlink != 0 {
+						if !w.followSymlinks {
+							continue
+						}
+						if fi, err = w.stat(path); err != nil {
+							errQueue <- err
+							continue
+						}
+					}
+					if ig.ignored(path, fi.IsDir()) {
+						continue
+					}
 					if fi.IsDir() {
-						nextDirs = append(nextDirs, filepath.Join(dir, fi.Name()))
-					} else if fi.Mode().IsRegular() {
+						if w.isExcludedDir(fi.Name()) {
+							continue
+						}
+						nextDirs = append(nextDirs, path)
+						nextIgnores = append(nextIgnores, ig)
+					} else if w.isReadable(fi.Mode()) {
 						w.wg.Add(1)
-						w.fileQueue <- filepath.Join(dir, fi.Name())
+						fileQueue <- path
 					}
 				}
 			}
 			if len(nextDirs) != 0 {
 				dirs = append(dirs[:0], nextDirs...)
+				ignores = append(ignores[:0], nextIgnores...)
 				nextDirs = nextDirs[:0]
+				nextIgnores = nextIgnores[:0]
+				depth++
 				goto NextDirs
 			}
 		}
 	}
 }
 
+// skip counts path as skipped by err, the path of err is used if err has.
+func (w *Walker) skip(path string, err error) {
+	w.stats.addSkip(err)
+	reason := err
+	switch e := err.(type) {
+	case *ExpectedError:
+		path, reason = e.path, e.err
+	case *os.PathError:
+		path, reason = e.Path, e.Err
+	}
+	w.logger.Debugf("skip %s: %v", path, reason)
+	w.mu.Lock()
+	w.skipped = append(w.skipped, SkippedFile{Path: w.displayPath(path), Reason: reason})
+	w.mu.Unlock()
+}
+
+// Skipped returns files that skipped in the last scan and the reasons.
+// it should be called after wait.
+func (w *Walker) Skipped() []SkippedFile {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]SkippedFile(nil), w.skipped...)
+}
+
+// TagCounts returns number of matched lines for each patterns in the last
+// scan, keys are patterns, or indexes of matchers if SetMatchers.
+// a line matched several patterns is counted for each. lines are not
+// counted if count only. it should be called after wait.
+func (w *Walker) TagCounts() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	counts := make(map[string]int, len(w.tagCounts))
+	for i := range w.tagCounts {
+		key := strconv.Itoa(i)
+		if i < len(w.pats) {
+			key = w.pats[i]
+		}
+		counts[key] = int(atomic.LoadInt64(&w.tagCounts[i]))
+	}
+	return counts
+}
+
+// Scanned returns paths of files that read in the last scan, the files are
+// accepted by filters. files dropped by SetDedupByContent or SetMaxMatches
+// are included. it should be called after wait.
+func (w *Walker) Scanned() []string {
+	w.scannedMu.Lock()
+	defer w.scannedMu.Unlock()
+	return append([]string(nil), w.scanned...)
+}
+
+// acceptFile reports whether file should be read.
+func (w *Walker) acceptFile(file string, errQueue chan<- error) bool {
+	if file == StdinPath {
+		return !w.check(file)
+	}
+	if checked, err := w.checkPath(file); checked {
+		if err != nil {
+			errQueue <- err
+		}
+		return false
+	}
+	if !w.isAllowedPath(file) || !w.isAllowedExt(file) || !w.isAllowedGlob(file) {
+		return false
+	}
+	if w.maxFileSize != 0 || !w.modifiedSince.IsZero() {
+		fi, err := w.stat(file)
+		if err != nil {
+			w.skip(file, err)
+			errQueue <- err
+			return false
+		}
+		if fi.ModTime().Before(w.modifiedSince) {
+			return false
+		}
+		if w.maxFileSize != 0 && fi.Size() > w.maxFileSize {
+			err := &ExpectedError{path: file, err: ErrTooLarge}
+			w.skip(file, err)
+			errQueue <- err
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Walker) readFile(fr *FileReader, path string) (*File, error) {
+	f, err := w.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	setReadDeadline(f, fr.deadline)
+	if w.readGzip && strings.HasSuffix(path, gzipExt) {
+		return w.readGzipFile(fr, path, f)
+	}
+	return w.read(fr, path, f)
+}
+
+const gzipExt = ".gz"
+
+func (w *Walker) readGzipFile(fr *FileReader, path string, r io.Reader) (*File, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, &ExpectedError{path: path, err: err}
+	}
+	defer zr.Close()
+	f, err := w.read(fr, path, zr)
+	if err != nil {
+		if _, ok := err.(*ExpectedError); ok {
+			return nil, err
+		}
+		// corrupted
+		return nil, &ExpectedError{path: path, err: err}
+	}
+	return f, nil
+}
+
+// read returns nil *File if content is duplicated.
+func (w *Walker) read(fr *FileReader, path string, r io.Reader) (*File, error) {
+	cr := &countReader{r: r}
+	defer func() { w.stats.addBytes(cr.n) }()
+	r = cr
+	if !w.dedupByContent {
+		f, err := fr.Read(path, r)
+		if err == nil {
+			w.addScanned(path)
+		}
+		return f, err
+	}
+	h := sha256.New()
+	tee := io.TeeReader(r, h)
+	f, err := fr.Read(path, tee)
+	if err != nil {
+		return nil, err
+	}
+	w.addScanned(path)
+	// rest of content if reading is stopped
+	if _, err = io.Copy(ioutil.Discard, tee); err != nil {
+		return nil, err
+	}
+	if w.seen(h.Sum(nil)) {
+		return nil, nil
+	}
+	f.sum = h.Sum(nil)
+	return f, nil
+}
+
+// addScanned records path as read, duplicated files are included.
+func (w *Walker) addScanned(path string) {
+	path = w.displayPath(path)
+	w.scannedMu.Lock()
+	w.scanned = append(w.scanned, path)
+	w.scannedMu.Unlock()
+}
+
+// seen reports whether content of sum is already read, and records sum.
+func (w *Walker) seen(sum []byte) bool {
+	var key [sha256.Size]byte
+	copy(key[:], sum)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.hashes[key] {
+		return true
+	}
+	w.hashes[key] = true
+	return false
+}
+
 // do something for files.
-func (w *Walker) fileWalker(done <-chan struct{}, rq chan<- *File, errQueue chan<- error) {
+// results are sent until abort is closed, ctx is canceled by abort or
+// reached max matches. openFiles is a semaphore for opening files.
+// tagCounts is counters for each patterns.
+func (w *Walker) fileWalker(ctx context.Context, abort <-chan struct{}, done <-chan struct{}, fileQueue <-chan string, openFiles chan struct{}, tagCounts []int64, cache *scanCache, rq chan<- *File, errQueue chan<- error) {
 	var file string
-	fr := NewFileReader(w.re, w.nbefore, w.nafter)
-	var f *File
-	var err error
+	fr := NewMatcherFileReader(w.ms, w.nbefore, w.nafter)
+	fr.invert = w.invert
+	fr.merge = w.merge
+	fr.countOnly = w.countOnly
+	fr.namesOnly = w.namesOnly
+	fr.lineBase = w.lineBase
+	fr.binary = w.binary
+	fr.decode = w.decode
+	fr.maxLineSize = w.maxLine
+	fr.splitCR = w.splitCR
+	fr.multiline = w.multiline
+	fr.maxWholeText = w.mlMaxBytes
+	fr.logger = w.logger
+	fr.maxMatches = w.maxFileMatches
+	fr.headLines = w.headLines
+	fr.tailLines = w.tailLines
+	fr.annotation = w.annotation
+	fr.header = w.header
+	fr.hl = w.hl
+	fr.trimIndent = w.trimIndent
+	if w.onMatch != nil {
+		fr.onMatch = func(path string, line uint, text string) {
+			w.onMatch(w.displayPath(path), line, text)
+		}
+	}
+	send := func(f *File, err error) {
+		if err != nil {
+			w.skip(file, err)
+			errQueue <- err
+			return
+		}
+		// duplicated content
+		if f == nil {
+			return
+		}
+		if w.matchPath && !w.invert && !f.candidate {
+			if c := nameContext(w.ms, filepath.Base(f.Path), w.hl); c != nil {
+				f.count++
+				if !w.countOnly {
+					f.Contexts = append([]*Context{c}, f.Contexts...)
+				}
+			}
+		}
+		if w.maxMatches != 0 && f.count != 0 {
+			n := int64(f.count)
+			total := atomic.AddInt64(&w.nmatches, n)
+			if rest := int64(w.maxMatches) - (total - n); rest <= 0 {
+				return
+			} else if rest < n {
+				f.truncate(int(rest), w.nafter)
+			}
+			if total >= int64(w.maxMatches) {
+				w.cancel()
+			}
+		}
+		f.root = w.displayPath(w.rootOf(f.Path))
+		f.Path = w.displayPath(f.Path)
+		if w.less != nil {
+			SortContexts(f.Contexts, w.less)
+		}
+		w.stats.addFile(f)
+		for _, c := range f.Contexts {
+			for _, m := range c.matches() {
+				for _, i := range m.patterns {
+					atomic.AddInt64(&tagCounts[i], 1)
+				}
+			}
+		}
+		select {
+		case rq <- f:
+		case <-abort:
+		}
+	}
 	for ; ; w.wg.Done() {
 		select {
 		case <-done:
 			return
-		case file = <-w.fileQueue:
-			if w.check(file) {
+		case file = <-fileQueue:
+			// drain after canceled
+			if ctx.Err() != nil {
 				continue
 			}
-			f, err = fr.ReadFile(file)
-			if err != nil {
-				errQueue <- err
+			if !w.acceptFile(file, errQueue) {
 				continue
 			}
-			rq <- f
+			fr.deadline = time.Time{}
+			if w.fileTimeout > 0 {
+				fr.deadline = time.Now().Add(w.fileTimeout)
+			}
+			switch {
+			case w.dryRun:
+				if file == StdinPath {
+					file = stdinName
+				}
+				send(&File{Path: file, candidate: true}, nil)
+			case file == StdinPath:
+				file = stdinName
+				send(w.read(fr, file, w.stdin))
+			case w.readArchive && archiveKind(file) != "":
+				// members are sent after the archive is closed, sending
+				// may be blocked by the result queue.
+				type member struct {
+					f   *File
+					err error
+				}
+				var members []member
+				openFiles <- struct{}{}
+				w.readArchiveFile(fr, file, func(f *File, err error) {
+					members = append(members, member{f, err})
+				})
+				<-openFiles
+				for _, m := range members {
+					send(m.f, m.err)
+				}
+			default:
+				var fi os.FileInfo
+				if cache != nil {
+					if fi, _ = w.stat(file); fi != nil {
+						// entries without sum are read again for dedup
+						if e := cache.get(file, fi); e != nil && (!w.dedupByContent || e.Sum != nil) {
+							w.addScanned(file)
+							if w.dedupByContent && w.seen(e.Sum) {
+								break
+							}
+							send(e.file(w, file), nil)
+							break
+						}
+					}
+				}
+				openFiles <- struct{}{}
+				f, err := w.readFile(fr, file)
+				<-openFiles
+				if err == nil && f != nil && w.gitBlame {
+					w.blame(f, w.lineBase)
+				}
+				if err == nil && f != nil && fi != nil {
+					cache.put(file, fi, f)
+				}
+				send(f, err)
+			}
+			w.logger.Debugf("file %s", file)
+			w.notifyProgress(file)
 		}
 	}
 }
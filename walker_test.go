@@ -1,10 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 // TODO: fix
@@ -17,7 +31,10 @@ func TestWalk(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	rec, wait := w.Start()
+	rec, wait, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = w.SendPath(dir)
 	if err != nil {
 		t.Fatal(err)
@@ -34,3 +51,2093 @@ func TestWalk(t *testing.T) {
 	}
 	t.Logf("out:\n%v", buf)
 }
+
+// walk collects results of w from paths.
+func walk(t *testing.T, w *Walker, paths ...string) []*File {
+	t.Helper()
+	rec, wait, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SendPath(paths...); err != nil {
+		t.Fatal(err)
+	}
+	go wait()
+	var fs []*File
+	for f := range rec {
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Path < fs[j].Path })
+	return fs
+}
+
+// relPaths returns paths of fs relative from dir.
+func relPaths(t *testing.T, dir string, fs []*File) []string {
+	t.Helper()
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range fs {
+		rel, err := filepath.Rel(abs, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	return paths
+}
+
+func TestSetExcludeDirs(t *testing.T) {
+	dir := filepath.Join("testdata", "walker")
+	tests := []struct {
+		names []string
+		exp   []string
+	}{
+		{
+			names: nil,
+			exp:   []string{"dir/file.txt", "file.txt", "symlink/file.txt"},
+		},
+		{
+			names: []string{"dir"},
+			exp:   []string{"file.txt", "symlink/file.txt"},
+		},
+		{
+			names: []string{"dir", "symlink"},
+			exp:   []string{"file.txt"},
+		},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("word"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetExcludeDirs(test.names...); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, dir, walk(t, w, dir))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("names=%q: out=%q, exp=%q", test.names, out, test.exp)
+		}
+	}
+}
+
+func TestSetExtensions(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_extensions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, name := range []string{"a.go", "b.js", "c.py", "d"} {
+		if err := ioutil.WriteFile(filepath.Join(tmp, name), []byte("TODO\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		exts []string
+		exp  []string
+	}{
+		{exts: nil, exp: []string{"a.go", "b.js", "c.py", "d"}},
+		{exts: []string{"go"}, exp: []string{"a.go"}},
+		{exts: []string{".go", "py"}, exp: []string{"a.go", "c.py"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetExtensions(test.exts...); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("exts=%q: out=%q, exp=%q", test.exts, out, test.exp)
+		}
+	}
+}
+
+func TestSetExcludeExtensions(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.js":     "TODO\n",
+		"a.min.js": "TODO\n",
+		"a.js.map": "TODO\n",
+		"b.go":     "TODO\n",
+		"min.js":   "TODO\n",
+	})
+	tests := []struct {
+		exts, excludes []string
+		exp            []string
+	}{
+		{excludes: []string{"min.js", ".map"}, exp: []string{"a.js", "b.go", "min.js"}},
+		{exts: []string{"js"}, excludes: []string{".min.js"}, exp: []string{"a.js", "min.js"}},
+		{exts: []string{"js"}, excludes: []string{"js"}, exp: nil},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetExtensions(test.exts...); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetExcludeExtensions(test.excludes...); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("exts=%q excludes=%q: out=%q, exp=%q", test.exts, test.excludes, out, test.exp)
+		}
+	}
+}
+
+func TestSetOrdered(t *testing.T) {
+	tmp := tempDir(t)
+	files := map[string]string{}
+	for _, name := range []string{"c.txt", "a.txt", "b/c.txt", "b/a.txt", "b/d/e.txt", "f/g.txt", "f/h/i/j.txt"} {
+		files[name] = "TODO\n"
+	}
+	writeFiles(t, tmp, files)
+	exp := []string{"a.txt", "c.txt", "b/a.txt", "b/c.txt", "f/g.txt", "b/d/e.txt", "f/h/i/j.txt"}
+	for _, maxMatches := range []int{0, 3} {
+		for i := 0; i != 5; i++ {
+			w := NewWalker()
+			if err := w.SetRegexp("TODO"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetWorkers(8); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetOrdered(true); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetMaxMatches(maxMatches); err != nil {
+				t.Fatal(err)
+			}
+			fs, err := w.Collect(tmp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := relPaths(t, tmp, fs)
+			for i := range out {
+				out[i] = filepath.ToSlash(out[i])
+			}
+			e := exp
+			if maxMatches != 0 {
+				e = exp[:maxMatches]
+			}
+			if !reflect.DeepEqual(out, e) {
+				t.Fatalf("max=%d: out=%q, exp=%q", maxMatches, out, e)
+			}
+		}
+	}
+}
+
+func TestSetModifiedSince(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"old.txt":     "TODO\n",
+		"old/new.txt": "TODO\n",
+	})
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	for _, name := range []string{"old.txt", "old"} {
+		if err := os.Chtimes(filepath.Join(tmp, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		since time.Time
+		exp   []string
+	}{
+		{exp: []string{"old.txt", "old/new.txt"}},
+		{since: now.Add(-24 * time.Hour), exp: []string{"old/new.txt"}},
+		{since: now.Add(time.Hour), exp: nil},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetModifiedSince(test.since); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		for i := range out {
+			out[i] = filepath.ToSlash(out[i])
+		}
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("since=%v: out=%q, exp=%q", test.since, out, test.exp)
+		}
+	}
+}
+
+func TestSetMatchPath(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"notes_TODO.txt": "nothing\n",
+		"TODO.md":        "# TODO\n",
+		"a.txt":          "TODO: a\n",
+		"b.txt":          "none\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMatchPath(true); err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, f := range walk(t, w, tmp) {
+		for _, c := range f.Contexts {
+			out = append(out, filepath.Base(f.Path)+":"+c.String())
+		}
+	}
+	exp := []string{"TODO.md:0:TODO.md\n", "TODO.md:1:# TODO\n", "a.txt:1:TODO: a\n", "notes_TODO.txt:0:notes_TODO.txt\n"}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestNoLeakAcrossFiles(t *testing.T) {
+	tmp := tempDir(t)
+	files := map[string]string{}
+	for i := 0; i != 20; i++ {
+		files[fmt.Sprintf("%02d.txt", i)] = fmt.Sprintf("%02d-1\n%02d-2\nTODO %02d\n", i, i, i)
+	}
+	writeFiles(t, tmp, files)
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkers(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(3, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range walk(t, w, tmp) {
+		prefix := strings.TrimSuffix(filepath.Base(f.Path), ".txt")
+		if len(f.Contexts) != 1 {
+			t.Fatalf("%s: contexts=%d", f.Path, len(f.Contexts))
+		}
+		for _, l := range f.Contexts[0].lines {
+			if !strings.Contains(l.Str, prefix) {
+				t.Errorf("%s: line of other file: %q", f.Path, l.Str)
+			}
+		}
+	}
+}
+
+func TestFileRoot(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a/a.txt":       "TODO: a\n",
+		"a/sub/s.txt":   "TODO: s\n",
+		"b/b.txt":       "TODO: b\n",
+		"c.txt":         "TODO: c\n",
+		"..cache/d.txt": "TODO: d\n",
+	})
+	abs, err := filepath.Abs(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		paths []string
+		exp   map[string]string
+	}{
+		{
+			paths: []string{"a", "b"},
+			exp: map[string]string{
+				"a/a.txt":     "a",
+				"a/sub/s.txt": "a",
+				"b/b.txt":     "b",
+			},
+		},
+		{
+			paths: []string{"", "a"},
+			exp: map[string]string{
+				"a/a.txt":       "a",
+				"a/sub/s.txt":   "a",
+				"b/b.txt":       "",
+				"c.txt":         "",
+				"..cache/d.txt": "",
+			},
+		},
+		{
+			paths: []string{"a", "a/sub", "c.txt"},
+			exp: map[string]string{
+				"a/a.txt":     "a",
+				"a/sub/s.txt": "a/sub",
+				"c.txt":       "c.txt",
+			},
+		},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, p := range test.paths {
+			paths = append(paths, filepath.Join(abs, filepath.FromSlash(p)))
+		}
+		fs := walk(t, w, paths...)
+		if len(fs) != len(test.exp) {
+			t.Fatalf("%v: files=%d, expected %d", test.paths, len(fs), len(test.exp))
+		}
+		for _, f := range fs {
+			rel, err := filepath.Rel(abs, f.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp := filepath.Join(abs, filepath.FromSlash(test.exp[filepath.ToSlash(rel)]))
+			if f.Root() != exp {
+				t.Errorf("%v: %s: root=%q, expected %q", test.paths, rel, f.Root(), exp)
+			}
+		}
+	}
+}
+
+func TestHadMatches(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"match/a.txt": "TODO: a\n",
+		"none/b.txt":  "none\n",
+	})
+	tests := []struct {
+		dir       string
+		countOnly bool
+		exp       bool
+	}{
+		{dir: "match", exp: true},
+		{dir: "match", countOnly: true, exp: true},
+		{dir: "none", exp: false},
+		{dir: "", exp: true},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetCountOnly(test.countOnly); err != nil {
+			t.Fatal(err)
+		}
+		walk(t, w, filepath.Join(tmp, test.dir))
+		if out := w.HadMatches(); out != test.exp {
+			t.Errorf("dir=%q countOnly=%v: out=%v, exp=%v", test.dir, test.countOnly, out, test.exp)
+		}
+	}
+}
+
+func TestSetOnMatch(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":     "TODO: a1\nnone\nTODO: a3\n",
+		"sub/b.txt": "none\nnone\nnone\nTODO: b4\n",
+		"c.txt":     "none\n",
+	})
+	var mu sync.Mutex
+	var out []string
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWorkers(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetBasePath(tmp); err != nil {
+		t.Fatal(err)
+	}
+	err := w.SetOnMatch(func(path string, line uint, text string) {
+		mu.Lock()
+		defer mu.Unlock()
+		out = append(out, fmt.Sprintf("%s:%d:%s", filepath.ToSlash(path), line, text))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walk(t, w, tmp)
+	sort.Strings(out)
+	exp := []string{"a.txt:1:TODO: a1", "a.txt:3:TODO: a3", "sub/b.txt:4:TODO: b4"}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	tmp, err := ioutil.TempDir("", "test_walker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	return tmp
+}
+
+// writeFiles writes files that key is slash separated path from dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSetFollowSymlinks(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":     "TODO\n",
+		"sub/b.txt": "TODO\n",
+	})
+	for old, name := range map[string]string{
+		"sub": "link",
+		".":   "loop",
+	} {
+		if err := os.Symlink(old, filepath.Join(tmp, name)); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	tests := []struct {
+		follow bool
+		exp    []string
+	}{
+		{follow: false, exp: []string{"a.txt", "sub/b.txt"}},
+		{follow: true, exp: []string{"a.txt", "link/b.txt"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetFollowSymlinks(test.follow); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("follow=%v: out=%q, exp=%q", test.follow, out, test.exp)
+		}
+	}
+}
+
+func TestSetMaxFileSize(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"small.txt": "TODO\n",
+		"large.txt": "TODO\n" + strings.Repeat("x", 1024) + "\n",
+	})
+
+	tests := []struct {
+		size int64
+		exp  []string
+	}{
+		{size: 0, exp: []string{"large.txt", "small.txt"}},
+		{size: 1024, exp: []string{"small.txt"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetMaxFileSize(test.size); err != nil {
+			t.Fatal(err)
+		}
+		var skipped []error
+		var mu sync.Mutex
+		if err := w.SetErrorHandler(func(err error) {
+			mu.Lock()
+			skipped = append(skipped, err)
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("size=%d: out=%q, exp=%q", test.size, out, test.exp)
+		}
+		if code := w.WaitExitCode(); code != 0 {
+			t.Errorf("size=%d: exit code %d", test.size, code)
+		}
+		mu.Lock()
+		if test.size != 0 && len(skipped) != 1 {
+			t.Errorf("size=%d: skipped=%v", test.size, skipped)
+		}
+		mu.Unlock()
+	}
+}
+
+// matchedLines returns matched lines of fs.
+func matchedLines(fs []*File) []string {
+	var lines []string
+	for _, f := range fs {
+		for _, c := range f.Contexts {
+			lines = append(lines, c.lines[c.index].Str)
+		}
+	}
+	return lines
+}
+
+func TestSetIgnoreCase(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO\ntodo\nToDo\nnone\n",
+	})
+
+	tests := []struct {
+		pat    string
+		ignore bool
+		exp    []string
+	}{
+		{pat: "TODO", ignore: false, exp: []string{"TODO"}},
+		{pat: "TODO", ignore: true, exp: []string{"TODO", "todo", "ToDo"}},
+		{pat: "(?s)todo", ignore: true, exp: []string{"TODO", "todo", "ToDo"}},
+		{pat: "(?-i)todo", ignore: true, exp: []string{"todo"}},
+	}
+	for _, test := range tests {
+		// order of setters is not matter
+		for _, ignoreFirst := range []bool{false, true} {
+			w := NewWalker()
+			if ignoreFirst {
+				if err := w.SetIgnoreCase(test.ignore); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.SetRegexp(test.pat); err != nil {
+				t.Fatal(err)
+			}
+			if !ignoreFirst {
+				if err := w.SetIgnoreCase(test.ignore); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out := matchedLines(walk(t, w, tmp))
+			if !reflect.DeepEqual(out, test.exp) {
+				t.Errorf("pat=%q ignore=%v: out=%q, exp=%q", test.pat, test.ignore, out, test.exp)
+			}
+		}
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	tmp := tempDir(t)
+	files := make(map[string]string)
+	for i := 0; i != 300; i++ {
+		files[fmt.Sprintf("d%d/f%d.txt", i%10, i)] = "TODO\n"
+	}
+	writeFiles(t, tmp, files)
+
+	baseline := runtime.NumGoroutine()
+
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec, wait, err := w.StartContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SendPath(tmp); err != nil {
+		t.Fatal(err)
+	}
+	go wait()
+
+	<-rec
+	cancel()
+	n := 1
+	for range rec {
+		n++
+	}
+	if n == len(files) {
+		t.Errorf("scan is not canceled")
+	}
+	if err := w.Err(); err != context.Canceled {
+		t.Errorf("Err()=%v, exp %v", err, context.Canceled)
+	}
+
+	for i := 0; runtime.NumGoroutine() > baseline; i++ {
+		if i == 100 {
+			t.Fatalf("goroutines leaked: %d > %d", runtime.NumGoroutine(), baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetWorkers(t *testing.T) {
+	if err := NewWalker().SetWorkers(0); err == nil {
+		t.Error("expected error for 0 workers")
+	}
+
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"b.txt":     "TODO\n",
+		"a.txt":     "TODO\n",
+		"c/a.txt":   "TODO\n",
+		"c/b/a.txt": "TODO\n",
+		"b/a.txt":   "TODO\n",
+	})
+	exp := []string{"a.txt", "b.txt", "b/a.txt", "c/a.txt", "c/b/a.txt"}
+
+	for i := 0; i != 3; i++ {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetWorkers(1); err != nil {
+			t.Fatal(err)
+		}
+		rec, wait, err := w.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SendPath(tmp); err != nil {
+			t.Fatal(err)
+		}
+		go wait()
+		var fs []*File
+		for f := range rec {
+			fs = append(fs, f)
+		}
+		// not sorted
+		if out := relPaths(t, tmp, fs); !reflect.DeepEqual(out, exp) {
+			t.Errorf("out=%q, exp=%q", out, exp)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"matched.txt":     "TODO\n",
+		"sub/matched.txt": "TODO\n",
+		"unmatched.txt":   "none\n",
+		"invalid.txt":     "TODO\xff\n",
+		"toolong.txt":     strings.Repeat("x", bufio.MaxScanTokenSize+1) + "\n",
+		"sub/sub/empty":   "",
+	})
+	exp := Stats{
+		DirsScanned:        3,
+		FilesScanned:       4,
+		FilesMatched:       2,
+		FilesSkipped:       2,
+		LinesMatched:       2,
+		SkippedTooLong:     1,
+		SkippedInvalidText: 1,
+	}
+	if os.Geteuid() != 0 {
+		writeFiles(t, tmp, map[string]string{"denied.txt": "TODO\n"})
+		if err := os.Chmod(filepath.Join(tmp, "denied.txt"), 0); err != nil {
+			t.Fatal(err)
+		}
+		exp.FilesSkipped++
+		exp.SkippedPermission++
+	}
+
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, w, tmp)
+	if w.Elapsed() <= 0 {
+		t.Errorf("elapsed=%v", w.Elapsed())
+	}
+	out := w.Stats()
+	// depends on buffering of skipped files, see TestBytesScanned
+	out.BytesScanned = 0
+	if out != exp {
+		t.Errorf("\nout=%+v\nexp=%+v", out, exp)
+	}
+}
+
+func TestStatsCountOnly(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO\nTODO\n",
+		"b.txt": "TODO\n",
+		"c.txt": "none\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetCountOnly(true); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, w, tmp)
+	out := w.Stats()
+	if out.FilesScanned != 3 || out.FilesMatched != 2 || out.LinesMatched != 3 {
+		t.Errorf("stats=%+v", out)
+	}
+	buf := new(bytes.Buffer)
+	if err := FprintSummary(buf, out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "scanned 3 files, 3 matches in 2 files (0s)\n"; buf.String() != exp {
+		t.Errorf("summary=%q, expected %q", buf.String(), exp)
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"matched.txt": "TODO\n",
+		"invalid.txt": "TODO\xff\n",
+		"toolong.txt": strings.Repeat("x", bufio.MaxScanTokenSize+1) + "\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, w, tmp)
+	out := make(map[string]error)
+	for _, s := range w.Skipped() {
+		rel, err := filepath.Rel(tmp, s.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[filepath.ToSlash(rel)] = s.Reason
+	}
+	exp := map[string]error{
+		"invalid.txt": ErrUnavailableText,
+		"toolong.txt": bufio.ErrTooLong,
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%v, exp=%v", out, exp)
+	}
+
+	if err := w.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.Skipped(); len(s) != 0 {
+		t.Errorf("after Reset: %v", s)
+	}
+}
+
+func TestSkippedTooLarge(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"small.txt": "TODO\n",
+		"large.txt": "TODO\n" + strings.Repeat("x", 100) + "\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMaxFileSize(10); err != nil {
+		t.Fatal(err)
+	}
+	if out := relPaths(t, tmp, walk(t, w, tmp)); !reflect.DeepEqual(out, []string{"small.txt"}) {
+		t.Errorf("out=%q", out)
+	}
+	skipped := w.Skipped()
+	if len(skipped) != 1 || filepath.Base(skipped[0].Path) != "large.txt" || skipped[0].Reason != ErrTooLarge {
+		t.Errorf("skipped=%v", skipped)
+	}
+	if n := w.Stats().FilesSkipped; n != 1 {
+		t.Errorf("files skipped=%d, exp 1", n)
+	}
+}
+
+func TestSetLineBase(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO: a\nb\nTODO: c\n",
+	})
+	w := NewWalker()
+	if err := w.SetLineBase(2); err == nil {
+		t.Error("expected error for base 2")
+	}
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, base := range []uint{1, 0} {
+		if err := w.SetLineBase(base); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		fs := walk(t, w, tmp)
+		if len(fs) != 1 {
+			t.Fatalf("files=%d", len(fs))
+		}
+		var out string
+		for _, c := range fs[0].Contexts {
+			out += c.String()
+		}
+		exp := fmt.Sprintf("%d:TODO: a\n%d-b\n%d:TODO: c\n", base, base+1, base+2)
+		if out != exp {
+			t.Errorf("base=%d: out=%q, exp=%q", base, out, exp)
+		}
+	}
+}
+
+func TestTagCounts(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":     "TODO: a\nFIXME: b\nTODO FIXME: c\n",
+		"sub/b.txt": "TODO: d\nnone\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexps("TODO", "FIXME", "HACK"); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, w, tmp)
+	exp := map[string]int{"TODO": 3, "FIXME": 2, "HACK": 0}
+	if out := w.TagCounts(); !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%v, exp=%v", out, exp)
+	}
+}
+
+func TestSetContextSort(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "a\nTODO: [P2] x\nb\nTODO: y\nc\nTODO: [P1] z\nd\nTODO: [P2] w\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetAnnotationPattern(regexp.MustCompile(`\[(?P<priority>P\d)\]`)); err != nil {
+		t.Fatal(err)
+	}
+	// by priority then line, no priority is the last
+	err := w.SetContextSort(func(a, b *Context) bool {
+		pa, pb := a.Annotation().Priority, b.Annotation().Priority
+		if pa == "" || pb == "" {
+			return pa != "" && pb == ""
+		}
+		return pa < pb
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := walk(t, w, tmp)
+	if len(fs) != 1 {
+		t.Fatalf("files=%d", len(fs))
+	}
+	var out string
+	for _, c := range fs[0].Contexts {
+		out += c.String()
+	}
+	exp := "5-c\n6:TODO: [P1] z\n" + "1-a\n2:TODO: [P2] x\n" + "7-d\n8:TODO: [P2] w\n" + "3-b\n4:TODO: y\n"
+	if out != exp {
+		t.Errorf("\nout=%q\nexp=%q", out, exp)
+	}
+}
+
+func TestSetInvertMatch(t *testing.T) {
+	dir := filepath.Join("testdata", "walker")
+	w := NewWalker()
+	if err := w.SetRegexp("word"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetInvertMatch(true); err != nil {
+		t.Fatal(err)
+	}
+	fs := walk(t, w, dir)
+	if len(fs) != 3 {
+		t.Fatalf("files=%d, exp 3", len(fs))
+	}
+	if out := matchedLines(fs); len(out) != 0 {
+		t.Errorf("out=%q, exp nothing", out)
+	}
+}
+
+func TestSetMaxDepth(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"1.txt":       "TODO\n",
+		"a/2.txt":     "TODO\n",
+		"a/b/3.txt":   "TODO\n",
+		"a/b/c/4.txt": "TODO\n",
+	})
+
+	tests := []struct {
+		depth int
+		exp   []string
+	}{
+		{depth: -1, exp: []string{"1.txt", "a/2.txt", "a/b/3.txt", "a/b/c/4.txt"}},
+		{depth: 0, exp: nil},
+		{depth: 1, exp: []string{"1.txt"}},
+		{depth: 2, exp: []string{"1.txt", "a/2.txt"}},
+		{depth: 3, exp: []string{"1.txt", "a/2.txt", "a/b/3.txt"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetMaxDepth(test.depth); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("depth=%d: out=%q, exp=%q", test.depth, out, test.exp)
+		}
+	}
+
+	// sent files are always scanned
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMaxDepth(0); err != nil {
+		t.Fatal(err)
+	}
+	if fs := walk(t, w, filepath.Join(tmp, "1.txt")); len(fs) != 1 {
+		t.Errorf("files=%d, exp 1", len(fs))
+	}
+}
+
+func TestSetSkipHidden(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":          "TODO\n",
+		".hidden.txt":    "TODO\n",
+		".git/config":    "TODO\n",
+		"sub/b.txt":      "TODO\n",
+		".config/c.txt":  "TODO\n",
+		".config/.d.txt": "TODO\n",
+	})
+
+	tests := []struct {
+		skip  bool
+		paths []string
+		exp   []string
+	}{
+		{
+			skip:  false,
+			paths: []string{tmp},
+			exp: []string{
+				".config/.d.txt", ".config/c.txt", ".git/config", ".hidden.txt",
+				"a.txt", "sub/b.txt",
+			},
+		},
+		{
+			skip:  true,
+			paths: []string{tmp},
+			exp:   []string{"a.txt", "sub/b.txt"},
+		},
+		{
+			skip:  true,
+			paths: []string{filepath.Join(tmp, ".config"), filepath.Join(tmp, ".hidden.txt")},
+			exp:   []string{".config/c.txt", ".hidden.txt"},
+		},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetSkipHidden(test.skip); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, test.paths...))
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("skip=%v: out=%q, exp=%q", test.skip, out, test.exp)
+		}
+	}
+}
+
+func TestStdin(t *testing.T) {
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetStdin(strings.NewReader("a\nTODO: b\nc\n")); err != nil {
+		t.Fatal(err)
+	}
+	fs := walk(t, w, StdinPath, StdinPath)
+	if len(fs) != 1 {
+		t.Fatalf("files=%d, exp 1", len(fs))
+	}
+	if fs[0].Path != "<stdin>" {
+		t.Errorf("path=%q", fs[0].Path)
+	}
+	if out, exp := fs[0].Contexts[0].String(), "1-a\n2:TODO: b\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+}
+
+func TestSetDedupByContent(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":     "TODO: same\n",
+		"sub/b.txt": "TODO: same\n",
+		"c.txt":     "TODO: other\n",
+	})
+
+	for _, dedup := range []bool{false, true} {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetDedupByContent(dedup); err != nil {
+			t.Fatal(err)
+		}
+		out := matchedLines(walk(t, w, tmp))
+		sort.Strings(out)
+		exp := []string{"TODO: other", "TODO: same"}
+		if !dedup {
+			exp = []string{"TODO: other", "TODO: same", "TODO: same"}
+		}
+		if !reflect.DeepEqual(out, exp) {
+			t.Errorf("dedup=%v: out=%q, exp=%q", dedup, out, exp)
+		}
+	}
+}
+
+func TestSetBasePath(t *testing.T) {
+	dir := filepath.Join("testdata", "walker")
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"dir/file.txt", "file.txt", "symlink/file.txt"}
+
+	for _, base := range []string{"", dir} {
+		w := NewWalker()
+		if err := w.SetRegexp("word"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetBasePath(base); err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, f := range walk(t, w, dir) {
+			if base == "" {
+				if !filepath.IsAbs(f.Path) {
+					t.Errorf("not absolute path: %q", f.Path)
+				}
+				f.Path, err = filepath.Rel(abs, f.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			out = append(out, filepath.ToSlash(f.Path))
+		}
+		if !reflect.DeepEqual(out, exp) {
+			t.Errorf("base=%q: out=%q, exp=%q", base, out, exp)
+		}
+	}
+}
+
+func TestSetReadGzip(t *testing.T) {
+	tmp := tempDir(t)
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write([]byte("a\nTODO: in gzip\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := buf.String()
+	writeFiles(t, tmp, map[string]string{
+		"a.log.gz":   compressed,
+		"corrupt.gz": compressed[:len(compressed)/2],
+		"header.gz":  "TODO: not gzip\n",
+		"b.log":      "TODO: plain\n",
+	})
+
+	tests := []struct {
+		read bool
+		exp  []string
+	}{
+		{read: false, exp: []string{"TODO: not gzip", "TODO: plain"}},
+		{read: true, exp: []string{"TODO: in gzip", "TODO: plain"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetReadGzip(test.read); err != nil {
+			t.Fatal(err)
+		}
+		var mu sync.Mutex
+		var errs []error
+		if err := w.SetErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+			DefaultErrorHandler(err)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		out := matchedLines(walk(t, w, tmp))
+		sort.Strings(out)
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("read=%v: out=%q, exp=%q", test.read, out, test.exp)
+		}
+		mu.Lock()
+		if test.read && len(errs) != 2 {
+			t.Errorf("read=%v: errors=%v, exp 2 errors", test.read, errs)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestCollect(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "a\nTODO: a\nb\n",
+		"b.txt": "none\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := w.Collect(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || len(fs[0].Contexts) != 1 {
+		t.Fatalf("out=%+v", fs)
+	}
+	c := fs[0].Contexts[0]
+	if c.Line().Str != "TODO: a" || len(c.Before()) != 1 || c.Before()[0].Str != "a" ||
+		len(c.After()) != 1 || c.After()[0].Str != "b" {
+		t.Errorf("context=%q", c.String())
+	}
+
+	if _, err = w.Collect(filepath.Join(tmp, "not_exist")); err == nil {
+		t.Error("expected error for not exist path")
+	}
+}
+
+// manyPaths writes n files that contain "TODO" and returns the paths, n is
+// larger than capacities of the queues.
+func manyPaths(t *testing.T, n int) []string {
+	t.Helper()
+	tmp := tempDir(t)
+	files := make(map[string]string, n)
+	paths := make([]string, n)
+	for i := range paths {
+		name := fmt.Sprintf("%03d.txt", i)
+		files[name] = "TODO\n"
+		paths[i] = filepath.Join(tmp, name)
+	}
+	writeFiles(t, tmp, files)
+	return paths
+}
+
+// withTimeout fails t if fn does not return in time.
+func withTimeout(t *testing.T, d time.Duration, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("not returned in %v", d)
+	}
+}
+
+func TestCollectManyPaths(t *testing.T) {
+	paths := manyPaths(t, 600)
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	var fs []*File
+	var err error
+	withTimeout(t, 10*time.Second, func() { fs, err = w.Collect(paths...) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != len(paths) {
+		t.Errorf("files=%d, exp %d", len(fs), len(paths))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":   "a\nTODO: a\nb\n",
+		"b.txt":   "none\n",
+		"c/c.txt": "TODO: c\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	var exp []*File
+	for _, f := range walk(t, w, tmp) {
+		if f.Count() != 0 {
+			exp = append(exp, f)
+		}
+	}
+	for i := 0; i != 2; i++ {
+		out, err := Search("TODO", 1, tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, exp) {
+			t.Errorf("out=%+v, exp=%+v", out, exp)
+		}
+	}
+
+	if _, err := Search("(", 0, tmp); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if _, err := Search("TODO", 0, filepath.Join(tmp, "not_exist")); err == nil {
+		t.Error("expected error for not exist path")
+	}
+}
+
+func TestSearchManyPaths(t *testing.T) {
+	paths := manyPaths(t, 600)
+	var fs []*File
+	var err error
+	withTimeout(t, 10*time.Second, func() { fs, err = Search("TODO", 0, paths...) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != len(paths) {
+		t.Fatalf("files=%d, exp %d", len(fs), len(paths))
+	}
+	for i, f := range fs {
+		if f.Path != paths[i] {
+			t.Fatalf("%d: path=%q, exp %q", i, f.Path, paths[i])
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":   "TODO: a\n",
+		"b/b.txt": "TODO: b\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"a.txt", "b/b.txt"}
+	if out := relPaths(t, tmp, walk(t, w, tmp)); !reflect.DeepEqual(out, exp) {
+		t.Fatalf("first: out=%q", out)
+	}
+	// checked files are skipped without Reset
+	if out := relPaths(t, tmp, walk(t, w, tmp)); len(out) != 0 {
+		t.Errorf("without Reset: out=%q", out)
+	}
+	if _, err := w.Collect(filepath.Join(tmp, "not_exist")); err == nil {
+		t.Fatal("expected error for not exist path")
+	}
+	if err := w.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err()=%v after Reset", err)
+	}
+	if out := relPaths(t, tmp, walk(t, w, tmp)); !reflect.DeepEqual(out, exp) {
+		t.Errorf("after Reset: out=%q", out)
+	}
+
+	rq, wait, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reset(); err != ErrAlreadyStarted {
+		t.Errorf("Reset on scanning: err=%v", err)
+	}
+	go wait()
+	for range rq {
+	}
+}
+
+func TestFilesWithoutMatch(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.go":        "// Copyright\npackage a\n",
+		"b.go":        "package b\n",
+		"c.txt":       "none\n",
+		"vendor/d.go": "package d\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("Copyright"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetExtensions(".go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetExcludeDirs("vendor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetBasePath(tmp); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := w.Collect(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned := w.Scanned()
+	sort.Strings(scanned)
+	if exp := []string{"a.go", "b.go"}; !reflect.DeepEqual(scanned, exp) {
+		t.Errorf("scanned=%q, exp=%q", scanned, exp)
+	}
+	buf := new(bytes.Buffer)
+	if err := FprintFilesWithoutMatch(buf, scanned, fs); err != nil {
+		t.Fatal(err)
+	}
+	if out, exp := buf.String(), "b.go\n"; out != exp {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+
+	// files dropped by dedup are read
+	writeFiles(t, tmp, map[string]string{"e.go": "package b\n"})
+	if err := w.SetDedupByContent(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Collect(tmp); err != nil {
+		t.Fatal(err)
+	}
+	scanned = w.Scanned()
+	sort.Strings(scanned)
+	if exp := []string{"a.go", "b.go", "e.go"}; !reflect.DeepEqual(scanned, exp) {
+		t.Errorf("dedup: scanned=%q, exp=%q", scanned, exp)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	tmp := tempDir(t)
+	files := make(map[string]string)
+	for i := 0; i != 200; i++ {
+		files[fmt.Sprintf("%d/%03d.txt", i%10, i)] = "TODO\n"
+	}
+	files["none.txt"] = "none\n"
+	writeFiles(t, tmp, files)
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err := w.ForEach(func(f *File) error {
+		if f.Count() == 0 {
+			t.Errorf("not matched file %s", f.Path)
+		}
+		n++
+		return nil
+	}, tmp)
+	if err != nil || n != 200 {
+		t.Errorf("n=%d, err=%v", n, err)
+	}
+
+	if err := w.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	n = 0
+	err = w.ForEach(func(f *File) error {
+		n++
+		return stop
+	}, tmp)
+	if err != stop || n != 1 {
+		t.Errorf("n=%d, err=%v", n, err)
+	}
+	if scanned := w.Stats().FilesScanned; scanned == 201 {
+		t.Errorf("the scan is not halted")
+	}
+	// not started, workers exited
+	if err := w.SetWorkers(1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestForEachFunc(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "a\nTODO: a\nb\n",
+		"b.txt": "none\n",
+		"c.txt": "TODO: c\n",
+	})
+	var out []string
+	err := ForEach("TODO", 1, func(f *File) error {
+		for _, c := range f.Contexts {
+			out = append(out, c.String())
+		}
+		return nil
+	}, filepath.Join(tmp, "a.txt"), filepath.Join(tmp, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"1-a\n2:TODO: a\n3-b\n"}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("out=%q, exp=%q", out, exp)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = ForEach("TODO", 0, func(f *File) error {
+		n++
+		return stop
+	}, tmp)
+	if err != stop || n != 1 {
+		t.Errorf("n=%d, err=%v", n, err)
+	}
+	if err := ForEach("(", 0, func(f *File) error { return nil }, tmp); err == nil {
+		t.Error("expected error for bad pattern")
+	}
+}
+
+func TestSendPathList(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":     "TODO: a\n",
+		"b.txt":     "TODO: b\n",
+		"sub/c.txt": "TODO: c\n",
+	})
+	list := strings.Join([]string{
+		"# changed files",
+		filepath.Join(tmp, "a.txt"),
+		"",
+		"  " + filepath.Join(tmp, "sub", "c.txt"),
+		filepath.Join(tmp, "sub"),
+		filepath.Join(tmp, "deleted.txt"),
+	}, "\n")
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	rq, wait, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SendPathList(strings.NewReader(list)); err != nil {
+		t.Fatal(err)
+	}
+	go wait()
+	var fs []*File
+	for f := range rq {
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Path < fs[j].Path })
+	if out := relPaths(t, tmp, fs); !reflect.DeepEqual(out, []string{"a.txt", "sub/c.txt"}) {
+		t.Errorf("out=%q", out)
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err()=%v", err)
+	}
+	if n := w.Stats().SkippedNotExist; n != 1 {
+		t.Errorf("SkippedNotExist=%d", n)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO: a\nb\nTODO: c\n",
+		"b.txt": "none\n",
+	})
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetContext(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMergeContext(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Collect(tmp); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tmp, "a.txt")
+	exp := []Match{
+		{Path: path, Line: 1, Text: "TODO: a"},
+		{Path: path, Line: 3, Text: "TODO: c"},
+	}
+	out := w.Matches()
+	if !reflect.DeepEqual(out, exp) {
+		t.Fatalf("out=%+v, exp=%+v", out, exp)
+	}
+	if s := out[1].String(); s != path+":3:TODO: c" {
+		t.Errorf("String()=%q", s)
+	}
+}
+
+// slowMatcher matches all lines slowly.
+type slowMatcher time.Duration
+
+func (m slowMatcher) Match(line string) bool {
+	time.Sleep(time.Duration(m))
+	return true
+}
+
+func TestFileTimeout(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"fast.txt": "a\n",
+		"slow.txt": strings.Repeat("a\n", 100),
+	})
+	w := NewWalker()
+	if err := w.SetMatcher(slowMatcher(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetFileTimeout(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	if err := w.SetErrorHandler(func(err error) { errs = append(errs, err) }); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	fs := walk(t, w, tmp)
+	if d := time.Since(start); d > time.Second/2 {
+		t.Errorf("timeout is not applied: %v", d)
+	}
+	if out := relPaths(t, tmp, fs); !reflect.DeepEqual(out, []string{"fast.txt"}) {
+		t.Errorf("out=%q", out)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("errs=%v", errs)
+	}
+	if e, ok := errs[0].(*ExpectedError); !ok || e.err != ErrTimeout {
+		t.Errorf("unexpected error: %v", errs[0])
+	}
+	if s := w.Stats(); s.SkippedTimeout != 1 {
+		t.Errorf("stats=%+v", s)
+	}
+	if code := w.WaitExitCode(); code != 0 {
+		t.Errorf("exitcode=%d", code)
+	}
+}
+
+func TestUnexpectedError(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO: a\n",
+	})
+	unexpected := errors.New("unexpected")
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetStdin(iotest.ErrReader(unexpected)); err != nil {
+		t.Fatal(err)
+	}
+	// not panic by DefaultErrorHandler
+	fs, err := w.Collect(StdinPath, tmp)
+	if err != unexpected {
+		t.Errorf("err=%v, exp %v", err, unexpected)
+	}
+	if out := relPaths(t, tmp, fs); !reflect.DeepEqual(out, []string{"a.txt"}) {
+		t.Errorf("the scan is not completed: %q", out)
+	}
+	if code := w.WaitExitCode(); code != 1 {
+		t.Errorf("exitcode=%d", code)
+	}
+}
+
+func TestErrorsIs(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"invalid.txt": "TODO\xff\n",
+	})
+	var errs []error
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetErrorHandler(func(err error) { errs = append(errs, err) }); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, w, tmp)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrUnavailableText) {
+		t.Errorf("errs=%v", errs)
+	}
+	if _, err := w.Collect(filepath.Join(tmp, "not_exist")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err=%v", err)
+	}
+
+	err := fmt.Errorf("wrapped: %w", &ExpectedError{path: "a", err: os.ErrPermission})
+	if !errors.Is(err, os.ErrPermission) || !isExpected(err) {
+		t.Errorf("%v is not permission error", err)
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.go":         "TODO\n",
+		"a_test.go":    "TODO\n",
+		"a.txt":        "TODO\n",
+		"vendor/b.go":  "TODO\n",
+		"vendor/b.txt": "TODO\n",
+		".hidden/c.go": "TODO\n",
+	})
+	tests := []struct {
+		include, exclude string
+		exts             []string
+		exp              []string
+	}{
+		{include: `\.go$`, exp: []string{".hidden/c.go", "a.go", "a_test.go", "vendor/b.go"}},
+		{exclude: `_test\.go$|/vendor/`, exp: []string{".hidden/c.go", "a.go", "a.txt"}},
+		{include: `\.go$`, exclude: `_test\.go$|/vendor/`, exp: []string{".hidden/c.go", "a.go"}},
+		{include: `/a[^/]*$`, exts: []string{"txt"}, exp: []string{"a.txt"}},
+	}
+	for _, test := range tests {
+		var include, exclude *regexp.Regexp
+		if test.include != "" {
+			include = regexp.MustCompile(test.include)
+		}
+		if test.exclude != "" {
+			exclude = regexp.MustCompile(test.exclude)
+		}
+		w := NewWalker()
+		if err := w.SetPathFilter(include, exclude); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetExtensions(test.exts...); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		for i := range out {
+			out[i] = filepath.ToSlash(out[i])
+		}
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("include=%q exclude=%q: out=%q, exp=%q", test.include, test.exclude, out, test.exp)
+		}
+	}
+}
+
+func TestGlobs(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"d.go":         "TODO\n",
+		"src/a.go":     "TODO\n",
+		"src/c.txt":    "TODO\n",
+		"src/x/y/b.go": "TODO\n",
+		"vendor/e.go":  "TODO\n",
+		"..d/f.txt":    "TODO\n",
+	})
+	tests := []struct {
+		globs []string
+		exp   []string
+	}{
+		{globs: []string{"src/**/*.go"}, exp: []string{"src/a.go", "src/x/y/b.go"}},
+		{globs: []string{"src/*.go"}, exp: []string{"src/a.go"}},
+		{globs: []string{"*.go"}, exp: []string{"d.go", "src/a.go", "src/x/y/b.go", "vendor/e.go"}},
+		{globs: []string{"/*.go", "**/*.txt"}, exp: []string{"..d/f.txt", "d.go", "src/c.txt"}},
+		{globs: []string{"..d/*.txt"}, exp: []string{"..d/f.txt"}},
+		{globs: []string{"src/**"}, exp: []string{"src/a.go", "src/c.txt", "src/x/y/b.go"}},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetGlobs(test.globs...); err != nil {
+			t.Fatal(err)
+		}
+		out := relPaths(t, tmp, walk(t, w, tmp))
+		for i := range out {
+			out[i] = filepath.ToSlash(out[i])
+		}
+		if !reflect.DeepEqual(out, test.exp) {
+			t.Errorf("globs=%q: out=%q, exp=%q", test.globs, out, test.exp)
+		}
+	}
+
+	// relative from the sent directory
+	w := NewWalker()
+	if err := w.SetGlobs("x/**/*.go"); err != nil {
+		t.Fatal(err)
+	}
+	out := relPaths(t, tmp, walk(t, w, filepath.Join(tmp, "src")))
+	if len(out) != 1 || filepath.ToSlash(out[0]) != "src/x/y/b.go" {
+		t.Errorf("out=%q", out)
+	}
+
+	if err := NewWalker().SetGlobs("[a"); err == nil {
+		t.Error("expected error for bad pattern")
+	}
+}
+
+func TestMaxMatches(t *testing.T) {
+	tmp := tempDir(t)
+	files := make(map[string]string)
+	for i := 0; i != 20; i++ {
+		files[fmt.Sprintf("%02d.txt", i)] = "TODO: 1\nTODO: 2\nnone\nTODO: 3\n"
+	}
+	writeFiles(t, tmp, files)
+	for _, merge := range []bool{false, true} {
+		for _, n := range []int{1, 3, 7} {
+			w := NewWalker()
+			if err := w.SetRegexp("TODO"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetContext(0, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetMergeContext(merge); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetMaxMatches(n); err != nil {
+				t.Fatal(err)
+			}
+			before := runtime.NumGoroutine()
+			fs, err := w.Collect(tmp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var count, lines int
+			for _, f := range fs {
+				count += f.Count()
+				lines += len(f.Matches())
+			}
+			if count != n || lines != n {
+				t.Errorf("merge=%v n=%d: count=%d, matched lines=%d", merge, n, count, lines)
+			}
+			// workers exit
+			for i := 0; runtime.NumGoroutine() > before && i != 100; i++ {
+				time.Sleep(time.Millisecond)
+			}
+			if g := runtime.NumGoroutine(); g > before {
+				t.Errorf("merge=%v n=%d: goroutines %d > %d", merge, n, g, before)
+			}
+		}
+	}
+}
+
+func TestMaxMatchesAfterLines(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{"a.txt": "TODO: 1\nTODO: 2\nafter\nTODO: 3\nnone\n"})
+	for _, merge := range []bool{false, true} {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetContext(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetMergeContext(merge); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetMaxMatches(2); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := w.Collect(filepath.Join(tmp, "a.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fs) != 1 {
+			t.Fatalf("merge=%v: files=%d, exp 1", merge, len(fs))
+		}
+		var nums []uint
+		for _, c := range fs[0].Contexts {
+			for _, l := range c.lines {
+				nums = append(nums, l.Num)
+			}
+		}
+		if exp := []uint{1, 2, 3}; !reflect.DeepEqual(nums, exp) {
+			t.Errorf("merge=%v: lines=%v, exp %v", merge, nums, exp)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	tmp := tempDir(t)
+	files := make(map[string]string)
+	for i := 0; i != 10; i++ {
+		files[fmt.Sprintf("%d.txt", i)] = "TODO\n"
+	}
+	writeFiles(t, tmp, files)
+	w := NewWalker()
+	if err := w.SetWorkers(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetProgressInterval(-1); err == nil {
+		t.Error("expected error for negative interval")
+	}
+	if err := w.SetProgressInterval(0); err != nil {
+		t.Fatal(err)
+	}
+	var dones []int
+	paths := make(map[string]bool)
+	err := w.SetProgress(func(filesDone int, currentPath string) {
+		dones = append(dones, filesDone)
+		paths[currentPath] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := walk(t, w, tmp)
+	if len(dones) != len(fs) || len(paths) != len(fs) {
+		t.Fatalf("called %d times for %d paths, exp %d", len(dones), len(paths), len(fs))
+	}
+	for i, n := range dones {
+		if n != i+1 {
+			t.Errorf("filesDone=%v", dones)
+			break
+		}
+	}
+	for _, f := range fs {
+		if !paths[f.Path] {
+			t.Errorf("not notified %q", f.Path)
+		}
+	}
+
+	// throttled, and counted from 0 for the next scan
+	tmp2 := tempDir(t)
+	writeFiles(t, tmp2, files)
+	if err := w.SetWorkers(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetProgressInterval(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	dones = nil
+	walk(t, w, tmp2)
+	if !reflect.DeepEqual(dones, []int{1}) {
+		t.Errorf("throttled: filesDone=%v", dones)
+	}
+}
+
+func TestBytesScanned(t *testing.T) {
+	tmp := tempDir(t)
+	files := map[string]string{
+		"a.txt":     "TODO: a\n",
+		"b.txt":     strings.Repeat("none\n", 10000),
+		"dir/c.txt": "あい\nTODO\n",
+	}
+	writeFiles(t, tmp, files)
+	var exp int64
+	for _, str := range files {
+		exp += int64(len(str))
+	}
+	w := NewWalker()
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, w, tmp)
+	if out := w.Stats().BytesScanned; out != exp {
+		t.Errorf("BytesScanned=%d, exp %d", out, exp)
+	}
+}
+
+func TestMaxBufferedFiles(t *testing.T) {
+	tmp := tempDir(t)
+	files := make(map[string]string)
+	for i := 0; i != 20; i++ {
+		files[fmt.Sprintf("%02d.txt", i)] = "TODO\n"
+	}
+	writeFiles(t, tmp, files)
+	w := NewWalker()
+	if err := w.SetWorkers(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMaxBufferedFiles(1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rq, wait, err := w.StartContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SendPath(tmp); err != nil {
+		t.Fatal(err)
+	}
+	// not received, workers are blocked
+	time.Sleep(50 * time.Millisecond)
+	if n := w.Stats().FilesScanned; n > 1+2 {
+		t.Errorf("scanned %d files without receiving", n)
+	}
+	cancel()
+	finished := make(chan struct{})
+	go func() {
+		wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock after canceled")
+	}
+	for range rq {
+	}
+	if err := w.Err(); err != context.Canceled {
+		t.Errorf("Err()=%v", err)
+	}
+}
+
+func TestSetQueueSizes(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt":     "TODO: a\n",
+		"b/b.txt":   "TODO: b\n",
+		"b/c/c.txt": "TODO: c\n",
+	})
+	w := NewWalker()
+	for _, size := range [][2]int{{0, 1}, {1, 0}, {-1, -1}} {
+		if err := w.SetQueueSizes(size[0], size[1]); err == nil {
+			t.Errorf("SetQueueSizes(%d, %d) expected error", size[0], size[1])
+		}
+	}
+	if err := w.SetRegexp("TODO"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetQueueSizes(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	out := relPaths(t, tmp, walk(t, w, tmp))
+	if expected := []string{"a.txt", "b/b.txt", "b/c/c.txt"}; !reflect.DeepEqual(out, expected) {
+		t.Errorf("out=%q expected=%q", out, expected)
+	}
+}
+
+func benchmarkWideTree(b *testing.B, fileCap, dirCap int) {
+	tmp, err := ioutil.TempDir("", "todogotcha")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for i := 0; i != 20; i++ {
+		dir := filepath.Join(tmp, fmt.Sprintf("%02d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j != 50; j++ {
+			file := filepath.Join(dir, fmt.Sprintf("%02d.txt", j))
+			if err := ioutil.WriteFile(file, []byte("line\nTODO\n"), 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i != b.N; i++ {
+		w := NewWalker()
+		if err := w.SetRegexp("TODO"); err != nil {
+			b.Fatal(err)
+		}
+		if fileCap != 0 {
+			if err := w.SetQueueSizes(fileCap, dirCap); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := w.Collect(tmp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueueSizesDefault(b *testing.B) { benchmarkWideTree(b, 0, 0) }
+func BenchmarkQueueSizesSmall(b *testing.B)   { benchmarkWideTree(b, 1, 1) }
+func BenchmarkQueueSizesLarge(b *testing.B)   { benchmarkWideTree(b, 4096, 64) }
+
+func TestSetRegexpEngine(t *testing.T) {
+	w := NewWalker()
+	if err := w.SetLiteral(false); err != nil {
+		t.Fatal(err)
+	}
+	pat := `(?<!//\s)TODO`
+	if err := w.SetRegexp(pat); err == nil {
+		t.Errorf("lookbehind is accepted by %s", EngineRegexp)
+	}
+	if err := w.SetRegexpEngine("unknown"); err == nil {
+		t.Error("unknown engine is accepted")
+	}
+	if err := w.SetRegexpEngine(EngineRegexp); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllowEmptyMatch(t *testing.T) {
+	tmp := tempDir(t)
+	writeFiles(t, tmp, map[string]string{
+		"a.txt": "TODO: a\n\nb\n",
+	})
+	tests := []struct {
+		pat      string
+		allow    bool
+		invert   bool
+		fullLine bool
+		err      error
+		nfiles   int
+	}{
+		{pat: ".*", err: ErrPatternMatchesEverything},
+		{pat: "TODO|", err: ErrPatternMatchesEverything},
+		{pat: "x*", err: ErrPatternMatchesEverything},
+		{pat: ".*", allow: true, nfiles: 1},
+		{pat: ".*", invert: true, nfiles: 0},
+		{pat: "TODO", nfiles: 1},
+		{pat: "^$", nfiles: 1},
+		{pat: `\s*`, fullLine: true, nfiles: 1},
+	}
+	for _, test := range tests {
+		w := NewWalker()
+		if err := w.SetRegexp(test.pat); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AllowEmptyMatch(test.allow); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetInvertMatch(test.invert); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetFullLine(test.fullLine); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := w.Collect(tmp)
+		if err != test.err {
+			t.Errorf("%q allow=%v: err=%v, expected %v", test.pat, test.allow, err, test.err)
+		}
+		if len(fs) != test.nfiles {
+			t.Errorf("%q allow=%v: files=%d, expected %d", test.pat, test.allow, len(fs), test.nfiles)
+		}
+	}
+}
+
+func TestStartPatternMatchesEverything(t *testing.T) {
+	w := NewWalker()
+	if err := w.SetRegexp(".*"); err != nil {
+		t.Fatal(err)
+	}
+	rq, wait, err := w.Start()
+	if err != ErrPatternMatchesEverything || rq != nil || wait != nil {
+		t.Fatalf("err=%v", err)
+	}
+	// the scan is not started
+	if err := w.AllowEmptyMatch(true); err != nil {
+		t.Fatal(err)
+	}
+	rq, wait, err = w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go wait()
+	for range rq {
+	}
+}